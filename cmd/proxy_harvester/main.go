@@ -2,17 +2,24 @@ package main
 
 import (
 	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
 	"github.com/overflow0verture/proxy_harvester/internal/apiserver"
 	"github.com/overflow0verture/proxy_harvester/internal/check"
 	"github.com/overflow0verture/proxy_harvester/internal/config"
+	"github.com/overflow0verture/proxy_harvester/internal/event"
 	"github.com/overflow0verture/proxy_harvester/internal/globals"
+	"github.com/overflow0verture/proxy_harvester/internal/lifecycle"
 	"github.com/overflow0verture/proxy_harvester/internal/logger"
+	"github.com/overflow0verture/proxy_harvester/internal/netutil"
 	"github.com/overflow0verture/proxy_harvester/internal/plugin"
 	"github.com/overflow0verture/proxy_harvester/internal/pool"
+	"github.com/overflow0verture/proxy_harvester/internal/scheduler"
 	"github.com/overflow0verture/proxy_harvester/internal/server"
-	"os"
-	"strings"
-	"time"
+	"github.com/overflow0verture/proxy_harvester/internal/user"
 )
 
 func main() {
@@ -33,23 +40,71 @@ func main() {
 		logger.IPSummaryInterval = time.Duration(cfg.Log.IPSummaryInterval) * time.Minute
 	}
 
+	// 启动代理检测审计日志
+	if cfg.Log.CheckAuditFile != "" {
+		auditLog, err := event.StartAuditLog(cfg.Log.CheckAuditFile, event.TypeCheck)
+		if err != nil {
+			logger.Error("启动检测审计日志失败: %v", err)
+		} else {
+			lifecycle.OnShutdownFunc(lifecycle.PhaseLogs, "检测审计日志", auditLog.Close)
+			logger.Info("检测审计日志写入: %s", cfg.Log.CheckAuditFile)
+		}
+	}
+	if cfg.Log.AccessLogFile != "" {
+		accessLog, err := event.StartAuditLog(cfg.Log.AccessLogFile, event.TypeAccess)
+		if err != nil {
+			logger.Error("启动访问日志失败: %v", err)
+		} else {
+			lifecycle.OnShutdownFunc(lifecycle.PhaseLogs, "访问日志", accessLog.Close)
+			logger.Info("访问日志写入: %s", cfg.Log.AccessLogFile)
+		}
+	}
+
 	// 3. 初始化全局通道
 	globals.InitFetchChannel(1000)
 
 	// 4. 初始化代理池
 	proxyStore := pool.InitProxyStore(cfg.Storage, 10) // 10为速率，可根据配置调整
+	lifecycle.OnShutdownFunc(lifecycle.PhaseStore, "代理池", func() error {
+		return pool.CloseStore(proxyStore)
+	})
+	pool.SetSessionTTL(time.Duration(cfg.Listener.SessionTTL) * time.Second)
+	if cfg.Listener.DialAttempts > 0 {
+		netutil.DialAttempts = cfg.Listener.DialAttempts
+	}
+	if cfg.Listener.DialBudget > 0 {
+		netutil.DialBudget = time.Duration(cfg.Listener.DialBudget) * time.Second
+	}
+	if err := netutil.SetProxyTLS(cfg.UpstreamTLS.Verify, cfg.UpstreamTLS.CAFile); err != nil {
+		logger.Error("上游代理TLS配置错误，不校验代理证书: %v", err)
+	}
+	if err := netutil.SetChain(cfg.Chain.Hops); err != nil {
+		logger.Error("代理链配置错误，不使用代理链: %v", err)
+	} else if len(netutil.Chain) > 0 {
+		logger.Info("上游代理链: %s", strings.Join(netutil.Chain, " -> "))
+	}
+
+	// 开启多用户认证
+	if strings.ToLower(cfg.Users.Switch) == "open" {
+		user.Init(user.InitUserStore(cfg.Users, cfg.Storage))
+	}
 
 	// 5. 启动检测worker
 	check.StartCheckWorkers(cfg.CheckSocks.MaxConcurrentReq, cfg.CheckSocks, proxyStore)
+	lifecycle.OnShutdown(lifecycle.PhaseChecks, "检测worker", check.StopCheckWorkers)
 
-	// 6. 启动 socks5 监听服务
-	go socks5server.StartServer(proxyStore, cfg.Listener, cfg.CheckSocks.Timeout)
+	// 6. 启动本地监听服务：[listener]、[httpListener]（如果启用）及[[listeners]]
+	for _, entry := range cfg.ListenerEntries() {
+		go socks5server.StartListener(proxyStore, entry, cfg.ACL, cfg.CheckSocks.Timeout)
+	}
 
 	// 7. 启动API服务器（如果启用）
 	if strings.ToLower(cfg.APIServer.Switch) == "open" {
 		apiServer := apiserver.NewAPIServer(proxyStore, cfg.APIServer.Token, cfg.APIServer.Port)
+		apiServer.SetClientConfig(cfg.ListenerEntries(), cfg.PAC)
+		lifecycle.OnShutdown(lifecycle.PhaseListeners, "API服务器", apiServer.Shutdown)
 		go func() {
-			if err := apiServer.Start(); err != nil {
+			if err := apiServer.Start(); err != nil && err != http.ErrServerClosed {
 				logger.Error("API服务器启动失败: %v", err)
 			}
 		}()
@@ -64,6 +119,11 @@ func main() {
 
 	// 10. 启动插件监控
 	plugin.WatchPluginFolder(cfg.Plugin, proxyStore)
+	lifecycle.OnShutdown(lifecycle.PhaseCron, "插件定时任务", plugin.StopPluginSystem)
+
+	// 启动代理存活自检定时任务
+	scheduler.Start(cfg, proxyStore)
+	lifecycle.OnShutdown(lifecycle.PhaseCron, "代理存活自检", scheduler.Stop)
 
 	// 11. 定期汇总代理池状态
 	go func() {
@@ -77,8 +137,9 @@ func main() {
 	// 12. 业务主循环/监听/定时任务等
 	logger.Info("proxy harvester 启动完成")
 
-	// 程序退出前关闭日志
-	defer logger.Close()
+	// 阻塞直到收到退出信号，按阶段关闭各服务
+	lifecycle.Wait(time.Duration(cfg.Shutdown.Timeout) * time.Second)
 
-	select {} // 阻塞主线程
+	// 最后关闭日志
+	logger.Close()
 }
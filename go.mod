@@ -3,7 +3,6 @@ module github.com/overflow0verture/proxy_harvester
 go 1.24.3
 
 require (
-	github.com/armon/go-socks5 v0.0.0-20160902184237-e75332964ef5
 	github.com/fsnotify/fsnotify v1.9.0
 	github.com/go-redis/redis/v8 v8.11.5
 	github.com/gookit/color v1.5.4
@@ -0,0 +1,171 @@
+package access
+
+import (
+	"sync"
+	"time"
+
+	"github.com/overflow0verture/proxy_harvester/internal/event"
+)
+
+// 本地监听类型
+const (
+	ListenerSocks5 = "socks5"
+	ListenerHTTP   = "http"
+)
+
+// 连接关闭原因
+const (
+	CloseClient     = "client_closed"   // 客户端先结束发送
+	CloseUpstream   = "upstream_closed" // 上游先结束发送
+	CloseError      = "error"           // 转发过程中读写出错
+	CloseDialFailed = "dial_failed"     // 连接上游失败
+	CloseCompleted  = "completed"       // 普通HTTP请求转发完成
+)
+
+// maxTracked 按用户、上游分别统计的最大条目数，超出后计入otherKey
+const maxTracked = 10000
+
+const otherKey = "other"
+
+// 连接事件的动作
+const (
+	ConnectionOpened = "opened"
+	ConnectionClosed = "closed"
+)
+
+// ConnectionEvent 本地监听的连接打开或关闭，打开时记录中的流量和时长为0
+type ConnectionEvent struct {
+	Action string `json:"action"`
+	Record
+}
+
+// Record 单个隧道连接（或普通HTTP请求）的访问记录
+type Record struct {
+	Listener    string    `json:"listener"`
+	Client      string    `json:"client"`
+	User        string    `json:"user,omitempty"`
+	Destination string    `json:"destination"`
+	Upstream    string    `json:"upstream,omitempty"`
+	BytesUp     int64     `json:"bytes_up"`   // 客户端发往目标的字节数
+	BytesDown   int64     `json:"bytes_down"` // 目标发往客户端的字节数
+	Start       time.Time `json:"start"`
+	DurationMs  int64     `json:"duration_ms"`
+	CloseReason string    `json:"close_reason"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Counters 聚合计数
+type Counters struct {
+	Connections int64 `json:"connections"` // 已结束的连接数（含连接上游失败）
+	Active      int64 `json:"active"`      // 当前打开的连接数
+	Failed      int64 `json:"failed"`      // 连接上游失败数
+	BytesUp     int64 `json:"bytes_up"`
+	BytesDown   int64 `json:"bytes_down"`
+	DurationMs  int64 `json:"duration_ms"` // 累计连接时长
+}
+
+// Stats 访问统计快照
+type Stats struct {
+	Total     Counters            `json:"total"`
+	Listeners map[string]Counters `json:"listeners"`
+	Users     map[string]Counters `json:"users"`
+	Upstreams map[string]Counters `json:"upstreams"`
+}
+
+var (
+	mu        sync.Mutex
+	total     Counters
+	listeners = make(map[string]*Counters)
+	users     = make(map[string]*Counters)
+	upstreams = make(map[string]*Counters)
+)
+
+// Begin 连接上游成功，开始转发
+func Begin(rec Record) {
+	mu.Lock()
+	for _, c := range countersFor(rec) {
+		c.Active++
+	}
+	mu.Unlock()
+	event.Publish(event.TypeConnection, ConnectionEvent{Action: ConnectionOpened, Record: rec})
+}
+
+// Finish 转发结束，记录计数并发布访问事件，需与Begin成对调用
+func Finish(rec Record) {
+	rec.DurationMs = time.Since(rec.Start).Milliseconds()
+	mu.Lock()
+	for _, c := range countersFor(rec) {
+		c.Active--
+		c.Connections++
+		c.BytesUp += rec.BytesUp
+		c.BytesDown += rec.BytesDown
+		c.DurationMs += rec.DurationMs
+	}
+	mu.Unlock()
+	event.Publish(event.TypeAccess, rec)
+	event.Publish(event.TypeConnection, ConnectionEvent{Action: ConnectionClosed, Record: rec})
+}
+
+// Failed 连接上游失败，记录计数并发布访问事件
+func Failed(rec Record, err error) {
+	rec.DurationMs = time.Since(rec.Start).Milliseconds()
+	rec.CloseReason = CloseDialFailed
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	mu.Lock()
+	for _, c := range countersFor(rec) {
+		c.Connections++
+		c.Failed++
+	}
+	mu.Unlock()
+	event.Publish(event.TypeAccess, rec)
+}
+
+// countersFor 记录对应的各项计数，调用方需持有mu
+func countersFor(rec Record) []*Counters {
+	result := []*Counters{&total, entry(listeners, rec.Listener)}
+	if rec.User != "" {
+		result = append(result, entry(users, rec.User))
+	}
+	if rec.Upstream != "" {
+		result = append(result, entry(upstreams, rec.Upstream))
+	}
+	return result
+}
+
+func entry(m map[string]*Counters, key string) *Counters {
+	c, ok := m[key]
+	if ok {
+		return c
+	}
+	if len(m) >= maxTracked {
+		key = otherKey
+		if c, ok = m[key]; ok {
+			return c
+		}
+	}
+	c = &Counters{}
+	m[key] = c
+	return c
+}
+
+// Snapshot 返回当前的访问统计
+func Snapshot() Stats {
+	mu.Lock()
+	defer mu.Unlock()
+	return Stats{
+		Total:     total,
+		Listeners: copyCounters(listeners),
+		Users:     copyCounters(users),
+		Upstreams: copyCounters(upstreams),
+	}
+}
+
+func copyCounters(m map[string]*Counters) map[string]Counters {
+	result := make(map[string]Counters, len(m))
+	for k, c := range m {
+		result[k] = *c
+	}
+	return result
+}
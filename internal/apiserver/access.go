@@ -0,0 +1,34 @@
+package apiserver
+
+import (
+	"net/http"
+
+	"github.com/overflow0verture/proxy_harvester/internal/access"
+	"github.com/overflow0verture/proxy_harvester/internal/user"
+)
+
+// handleGetAccessStats 查询本地监听的访问统计
+// 使用管理令牌时返回全部统计，使用用户令牌时只返回该用户的计数
+func (s *APIServer) handleGetAccessStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, 405, "只支持GET方法")
+		return
+	}
+
+	stats := access.Snapshot()
+	if acct := user.FromContext(r.Context()); acct != nil {
+		name := acct.Name()
+		s.writeJSON(w, map[string]interface{}{
+			"code":    200,
+			"message": "获取成功",
+			"data":    map[string]access.Counters{name: stats.Users[name]},
+		})
+		return
+	}
+
+	s.writeJSON(w, map[string]interface{}{
+		"code":    200,
+		"message": "获取成功",
+		"data":    stats,
+	})
+}
@@ -1,15 +1,18 @@
 package apiserver
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 
+	"github.com/overflow0verture/proxy_harvester/internal/config"
 	"github.com/overflow0verture/proxy_harvester/internal/logger"
 	"github.com/overflow0verture/proxy_harvester/internal/pool"
+	"github.com/overflow0verture/proxy_harvester/internal/user"
 )
 
 // APIServer API服务器
@@ -17,7 +20,14 @@ type APIServer struct {
 	proxyStore pool.ProxyStore
 	token      string
 	port       int
+	mu         sync.Mutex
 	server     *http.Server
+	checks     *checkHistory
+	listeners  []config.ListenerEntry // 生成PAC文件和客户端配置使用
+	pac        config.PACConfig
+	// streamsDone 关闭时通知事件推送流结束
+	streamsDone chan struct{}
+	closeOnce   sync.Once
 }
 
 // ProxyResponse 代理响应结构
@@ -26,6 +36,7 @@ type ProxyResponse struct {
 	Message string   `json:"message"`
 	Data    []string `json:"data"`
 	Count   int      `json:"count"`
+	Matched int      `json:"matched"` // 满足筛选条件的代理数
 	Total   int      `json:"total"`
 }
 
@@ -41,37 +52,80 @@ func NewAPIServer(proxyStore pool.ProxyStore, token string, port int) *APIServer
 		proxyStore: proxyStore,
 		token:      token,
 		port:       port,
+		checks:     newCheckHistory(),
+
+		streamsDone: make(chan struct{}),
 	}
 }
 
 // Start 启动API服务器
 func (s *APIServer) Start() error {
 	mux := http.NewServeMux()
-	
+
 	// 注册路由
 	mux.HandleFunc("/api/proxies", s.handleGetProxies)
 	mux.HandleFunc("/api/status", s.handleGetStatus)
+	mux.HandleFunc("/api/pool", s.handlePool)
+	mux.HandleFunc("/api/pool/bad", s.handleMarkBad)
+	mux.HandleFunc("/api/pool/recheck", s.handleRecheck)
+	mux.HandleFunc("/api/feedback", s.handleFeedback)
+	mux.HandleFunc("/api/checks", s.handleGetChecks)
+	mux.HandleFunc("/api/errors", s.handleGetErrorStats)
+	mux.HandleFunc("/api/sessions", s.handleSessions)
+	mux.HandleFunc("/api/users", s.handleGetUsers)
+	mux.HandleFunc("/api/access", s.handleGetAccessStats)
+	mux.HandleFunc("/api/pac", s.handleGetPAC)
+	mux.HandleFunc("/api/client-config", s.handleGetClientConfig)
+	mux.HandleFunc("/api/events", s.handleEvents)
+	mux.HandleFunc("/metrics", s.handleMetrics)
 	// mux.HandleFunc("/", s.handleIndex)
+	s.registerMetrics()
 
-	s.server = &http.Server{
+	server := &http.Server{
 		Addr:         fmt.Sprintf(":%d", s.port),
-		Handler:      s.loggingMiddleware(s.authMiddleware(mux)),
+		Handler:      s.loggingMiddleware(mux, s.authMiddleware(mux)),
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}
+	s.mu.Lock()
+	s.server = server
+	s.mu.Unlock()
 
 	logger.Info("API服务器启动在端口 %d", s.port)
-	return s.server.ListenAndServe()
+	return server.ListenAndServe()
 }
 
 // Stop 停止API服务器
 func (s *APIServer) Stop() error {
+	s.closeStreams()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.server != nil {
 		return s.server.Close()
 	}
 	return nil
 }
 
+// Shutdown 停止接受新请求并等待进行中的请求完成
+func (s *APIServer) Shutdown(ctx context.Context) error {
+	// 推送流不会自行结束，需先通知其返回，否则Shutdown会一直等到超时
+	s.closeStreams()
+	s.mu.Lock()
+	server := s.server
+	s.mu.Unlock()
+	if server != nil {
+		return server.Shutdown(ctx)
+	}
+	return nil
+}
+
+// closeStreams 通知全部事件推送流结束
+func (s *APIServer) closeStreams() {
+	s.closeOnce.Do(func() {
+		close(s.streamsDone)
+	})
+}
+
 // authMiddleware 认证中间件
 func (s *APIServer) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -87,21 +141,30 @@ func (s *APIServer) authMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		if token != s.token {
-			s.writeError(w, 401, "token无效")
+		if token == s.token {
+			next.ServeHTTP(w, r)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		// 开启多用户时也接受用户各自的令牌
+		acct, ok := user.ByToken(token)
+		if !ok {
+			s.writeError(w, 401, "token无效")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(user.WithAccount(r.Context(), acct)))
 	})
 }
 
-// loggingMiddleware 日志中间件
-func (s *APIServer) loggingMiddleware(next http.Handler) http.Handler {
+// loggingMiddleware 日志中间件，同时按路由记录请求耗时指标
+func (s *APIServer) loggingMiddleware(mux *http.ServeMux, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		next.ServeHTTP(w, r)
-		logger.Debug("API请求: %s %s - %v", r.Method, r.URL.Path, time.Since(start))
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		elapsed := time.Since(start)
+		apiRequestDuration.ObserveDuration(elapsed, routeLabel(mux, r), r.Method, statusLabel(rec.status))
+		logger.Debug("API请求: %s %s - %v", r.Method, r.URL.Path, elapsed)
 	})
 }
 
@@ -114,7 +177,6 @@ func (s *APIServer) handleGetProxies(w http.ResponseWriter, r *http.Request) {
 
 	// 解析参数
 	countStr := r.URL.Query().Get("count")
-	proxyType := r.URL.Query().Get("type")
 
 	// 默认值
 	count := 10
@@ -126,9 +188,14 @@ func (s *APIServer) handleGetProxies(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	query, err := parseProxyQuery(r.URL.Query())
+	if err != nil {
+		s.writeError(w, 400, err.Error())
+		return
+	}
 
-	// 获取代理池总数
-	total, err := s.proxyStore.Len()
+	// 从全量列表中筛选，不调用GetNext，避免推进监听的轮询位置和消耗限速令牌
+	proxies, total, err := s.matchedProxies(query)
 	if err != nil {
 		s.writeError(w, 500, "获取代理池状态失败")
 		return
@@ -145,43 +212,16 @@ func (s *APIServer) handleGetProxies(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 如果代理池中的数量小于请求数量，则调整count为实际可用数量
-	// 但仍然不能超过100个的限制
-	if total < count {
-		count = total
-		if count > 100 {
-			count = 100
-		}
-	}
-
-	// 获取代理
-	proxies := make([]string, 0, count)
-	attemptCount := 0
-	maxAttempts := count * 3 // 尝试最多3倍数量，以防类型过滤导致获取不足
-
-	for len(proxies) < count && attemptCount < maxAttempts {
-		proxy, err := s.proxyStore.GetNext()
-		if err != nil {
-			break // 没有更多代理了
-		}
-		attemptCount++
-
-		// 如果指定了代理类型，进行过滤
-		if proxyType != "" {
-			if !strings.HasPrefix(proxy, proxyType+"://") {
-				// 不匹配类型，尝试下一个
-				continue
-			}
-		}
-
-		proxies = append(proxies, proxy)
+	matched := len(proxies)
+	if len(proxies) > count {
+		proxies = proxies[:count]
 	}
-
 	s.writeJSON(w, ProxyResponse{
 		Code:    200,
 		Message: "获取成功",
 		Data:    proxies,
 		Count:   len(proxies),
+		Matched: matched,
 		Total:   total,
 	})
 }
@@ -230,9 +270,9 @@ func (s *APIServer) handleGetStatus(w http.ResponseWriter, r *http.Request) {
 //     <div class="container">
 //         <h1>🚀 Proxy Harvester API</h1>
 //         <p>代理池访问API服务</p>
-        
+
 //         <h2>📋 API接口</h2>
-        
+
 //         <div class="endpoint">
 //             <h3><span class="method">GET</span> /api/proxies</h3>
 //             <p><strong>功能：</strong>获取代理列表</p>
@@ -256,7 +296,7 @@ func (s *APIServer) handleGetStatus(w http.ResponseWriter, r *http.Request) {
 //                 <li><code>/api/proxies?token=atoken&count=1000</code> (最多返回100个)</li>
 //             </ul>
 //         </div>
-        
+
 //         <div class="endpoint">
 //             <h3><span class="method">GET</span> /api/status</h3>
 //             <p><strong>功能：</strong>获取代理池状态</p>
@@ -288,7 +328,7 @@ func (s *APIServer) handleGetStatus(w http.ResponseWriter, r *http.Request) {
 //     </div>
 // </body>
 // </html>`
-	
+
 // 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 // 	w.WriteHeader(http.StatusOK)
 // 	w.Write([]byte(html))
@@ -296,8 +336,13 @@ func (s *APIServer) handleGetStatus(w http.ResponseWriter, r *http.Request) {
 
 // writeJSON 写入JSON响应
 func (s *APIServer) writeJSON(w http.ResponseWriter, data interface{}) {
+	s.writeJSONStatus(w, http.StatusOK, data)
+}
+
+// writeJSONStatus 以指定状态码写入JSON响应，如创建成功201、已接受待处理202
+func (s *APIServer) writeJSONStatus(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(data)
 }
 
@@ -309,4 +354,4 @@ func (s *APIServer) writeError(w http.ResponseWriter, code int, message string)
 		Code:    code,
 		Message: message,
 	})
-} 
\ No newline at end of file
+}
@@ -0,0 +1,126 @@
+package apiserver
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/overflow0verture/proxy_harvester/internal/check"
+	"github.com/overflow0verture/proxy_harvester/internal/errclass"
+	"github.com/overflow0verture/proxy_harvester/internal/event"
+)
+
+// 最近检测记录保留条数
+const recentCheckLimit = 1000
+
+// checkHistory 订阅检测事件并保留最近的记录，供排查代理失败原因
+type checkHistory struct {
+	mu      sync.Mutex
+	records []check.CheckEvent
+}
+
+// newCheckHistory 创建检测记录缓存并开始订阅
+func newCheckHistory() *checkHistory {
+	h := &checkHistory{
+		records: make([]check.CheckEvent, 0, recentCheckLimit),
+	}
+	ch, _ := event.Subscribe(1024, event.TypeCheck)
+	go func() {
+		for ev := range ch {
+			if rec, ok := ev.Data.(check.CheckEvent); ok {
+				h.add(rec)
+			}
+		}
+	}()
+	return h
+}
+
+func (h *checkHistory) add(rec check.CheckEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.records) >= recentCheckLimit {
+		h.records = h.records[1:]
+	}
+	h.records = append(h.records, rec)
+}
+
+// query 按条件倒序返回最近的检测记录
+func (h *checkHistory) query(proxy, profile, errorClass string, alive string, limit int) []check.CheckEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	result := make([]check.CheckEvent, 0, limit)
+	for i := len(h.records) - 1; i >= 0 && len(result) < limit; i-- {
+		rec := h.records[i]
+		if proxy != "" && rec.Proxy != proxy {
+			continue
+		}
+		if profile != "" && rec.Profile != profile {
+			continue
+		}
+		if errorClass != "" && string(rec.ErrorClass) != errorClass {
+			continue
+		}
+		if alive != "" && strconv.FormatBool(rec.Alive) != alive {
+			continue
+		}
+		result = append(result, rec)
+	}
+	return result
+}
+
+// handleGetChecks 查询最近的代理检测记录
+func (s *APIServer) handleGetChecks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, 405, "只支持GET方法")
+		return
+	}
+
+	q := r.URL.Query()
+	limit := 100
+	if limitStr := q.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > recentCheckLimit {
+			s.writeError(w, 400, "limit参数无效，必须是1-1000之间的整数")
+			return
+		}
+	}
+
+	alive := q.Get("alive")
+	if alive != "" && alive != "true" && alive != "false" {
+		s.writeError(w, 400, "alive参数无效，必须是true或false")
+		return
+	}
+
+	records := s.checks.query(q.Get("proxy"), q.Get("profile"), q.Get("error_class"), alive, limit)
+	s.writeJSON(w, map[string]interface{}{
+		"code":    200,
+		"message": "获取成功",
+		"data":    records,
+		"count":   len(records),
+	})
+}
+
+// handleGetErrorStats 按来源插件查询失败分类统计
+func (s *APIServer) handleGetErrorStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, 405, "只支持GET方法")
+		return
+	}
+
+	stats := errclass.Snapshot()
+	if source := r.URL.Query().Get("source"); source != "" {
+		filtered := make(map[string]map[errclass.Class]int64)
+		if counts, ok := stats[source]; ok {
+			filtered[source] = counts
+		}
+		stats = filtered
+	}
+
+	s.writeJSON(w, map[string]interface{}{
+		"code":    200,
+		"message": "获取成功",
+		"data":    stats,
+	})
+}
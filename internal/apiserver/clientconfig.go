@@ -0,0 +1,349 @@
+package apiserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/overflow0verture/proxy_harvester/internal/config"
+	"github.com/overflow0verture/proxy_harvester/internal/user"
+)
+
+// 客户端配置片段格式
+const (
+	formatCurl        = "curl"
+	formatProxychains = "proxychains"
+	formatClash       = "clash"
+	formatEnv         = "env"
+)
+
+// clientListener 客户端可以连接的本地监听
+type clientListener struct {
+	name     string
+	protocol string
+	host     string
+	port     int
+	userName string
+	password string
+	udp      bool
+}
+
+// addr 监听的 host:port
+func (l clientListener) addr() string {
+	return net.JoinHostPort(l.host, strconv.Itoa(l.port))
+}
+
+// proxyURL 监听的代理地址，socks5使用socks5h由代理解析域名
+func (l clientListener) proxyURL() string {
+	u := url.URL{Scheme: "http", Host: l.addr()}
+	if l.protocol == config.ProtocolSocks5 {
+		u.Scheme = "socks5h"
+	}
+	if l.userName != "" {
+		u.User = url.UserPassword(l.userName, l.password)
+	}
+	return u.String()
+}
+
+// SetClientConfig 设置生成PAC文件和客户端配置使用的本地监听及规则
+func (s *APIServer) SetClientConfig(listeners []config.ListenerEntry, pac config.PACConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = listeners
+	s.pac = pac
+}
+
+// clientListeners 客户端可用的SOCKS5和HTTP监听，name不为空时只返回该监听
+// 监听地址为空或0.0.0.0时使用配置的host，未配置时使用API请求的主机名
+// 使用用户令牌时填入该用户的认证信息，开启多用户时管理令牌不填认证信息
+func (s *APIServer) clientListeners(r *http.Request, name string) []clientListener {
+	s.mu.Lock()
+	entries, pac := s.listeners, s.pac
+	s.mu.Unlock()
+
+	requestHost := r.Host
+	if h, _, err := net.SplitHostPort(r.Host); err == nil {
+		requestHost = h
+	}
+	acct := user.FromContext(r.Context())
+
+	var result []clientListener
+	for _, entry := range entries {
+		if entry.Protocol != config.ProtocolSocks5 && entry.Protocol != config.ProtocolHTTP {
+			continue
+		}
+		if name != "" && entry.Name != name {
+			continue
+		}
+		l := clientListener{
+			name:     entry.Name,
+			protocol: entry.Protocol,
+			host:     pac.Host,
+			port:     entry.Port,
+			udp:      entry.UDP,
+		}
+		if l.host == "" {
+			l.host = entry.IP
+			if ip := net.ParseIP(entry.IP); entry.IP == "" || (ip != nil && ip.IsUnspecified()) {
+				l.host = requestHost
+			}
+		}
+		switch {
+		case acct != nil:
+			l.userName, l.password = acct.User().Name, acct.User().Password
+		case !user.Enabled() && entry.UserName != "" && entry.Password != "":
+			l.userName, l.password = entry.UserName, entry.Password
+		}
+		result = append(result, l)
+	}
+	return result
+}
+
+// handleGetPAC 生成PAC文件，按[pac]中的规则决定经本地监听还是直连
+func (s *APIServer) handleGetPAC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, 405, "只支持GET方法")
+		return
+	}
+
+	s.mu.Lock()
+	pac := s.pac
+	s.mu.Unlock()
+	name := r.URL.Query().Get("listener")
+	if name == "" {
+		name = pac.Listener
+	}
+	listeners := s.clientListeners(r, name)
+	if len(listeners) == 0 {
+		s.writeError(w, 404, "没有可用的SOCKS5或HTTP监听")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+	w.Header().Set("Content-Disposition", `inline; filename="proxy.pac"`)
+	w.Write([]byte(buildPAC(listeners, pac)))
+}
+
+// buildPAC 生成PAC脚本，direct规则优先，其次proxy规则，都不匹配时按default处理
+// 浏览器不支持SOCKS5认证，PAC中不包含认证信息
+func buildPAC(listeners []clientListener, pac config.PACConfig) string {
+	var proxies []string
+	for _, l := range listeners {
+		if l.protocol == config.ProtocolSocks5 {
+			proxies = append(proxies, "SOCKS5 "+l.addr(), "SOCKS "+l.addr())
+		} else {
+			proxies = append(proxies, "PROXY "+l.addr())
+		}
+	}
+	proxy := strings.Join(proxies, "; ")
+	fallback := proxy
+	if strings.ToLower(pac.Default) == "direct" {
+		fallback = "DIRECT"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "var PROXY = %q;\n", proxy)
+	fmt.Fprintf(&b, "var DIRECT_RULES = %s;\n", jsStringArray(pac.Direct))
+	fmt.Fprintf(&b, "var PROXY_RULES = %s;\n\n", jsStringArray(pac.Proxy))
+	b.WriteString(`function matchRule(host, rule) {
+    var slash = rule.indexOf("/");
+    if (slash > 0) {
+        if (!/^\d+\.\d+\.\d+\.\d+$/.test(host)) {
+            return false;
+        }
+        var bits = parseInt(rule.substring(slash + 1), 10);
+        var mask = [];
+        for (var i = 0; i < 4; i++) {
+            var n = Math.max(0, Math.min(8, bits - i * 8));
+            mask.push(256 - Math.pow(2, 8 - n));
+        }
+        return isInNet(host, rule.substring(0, slash), mask.join("."));
+    }
+    if (rule.indexOf("*.") === 0 && host === rule.substring(2)) {
+        return true;
+    }
+    return shExpMatch(host, rule);
+}
+
+function matchAny(host, rules) {
+    for (var i = 0; i < rules.length; i++) {
+        if (matchRule(host, rules[i])) {
+            return true;
+        }
+    }
+    return false;
+}
+
+function FindProxyForURL(url, host) {
+    if (isPlainHostName(host) || matchAny(host, DIRECT_RULES)) {
+        return "DIRECT";
+    }
+    if (matchAny(host, PROXY_RULES)) {
+        return PROXY;
+    }
+`)
+	fmt.Fprintf(&b, "    return %q;\n}\n", fallback)
+	return b.String()
+}
+
+// jsStringArray 转为JavaScript字符串数组字面量
+func jsStringArray(items []string) string {
+	quoted := make([]string, 0, len(items))
+	for _, item := range items {
+		quoted = append(quoted, strconv.Quote(item))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// handleGetClientConfig 生成curl、proxychains、Clash和环境变量的配置片段
+// 指定format时返回纯文本，否则以JSON返回全部格式
+func (s *APIServer) handleGetClientConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, 405, "只支持GET方法")
+		return
+	}
+
+	listeners := s.clientListeners(r, r.URL.Query().Get("listener"))
+	if len(listeners) == 0 {
+		s.writeError(w, 404, "没有可用的SOCKS5或HTTP监听")
+		return
+	}
+	s.mu.Lock()
+	pac := s.pac
+	s.mu.Unlock()
+
+	snippets := map[string]string{
+		formatCurl:        curlSnippet(listeners),
+		formatProxychains: proxychainsSnippet(listeners),
+		formatClash:       clashSnippet(listeners, pac),
+		formatEnv:         envSnippet(listeners, pac),
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		s.writeJSON(w, map[string]interface{}{
+			"code":    200,
+			"message": "获取成功",
+			"data":    snippets,
+		})
+		return
+	}
+	snippet, ok := snippets[format]
+	if !ok {
+		s.writeError(w, 400, "format参数无效，可选curl、proxychains、clash、env")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(snippet))
+}
+
+// firstListener 第一个指定协议的监听
+func firstListener(listeners []clientListener, protocol string) (clientListener, bool) {
+	for _, l := range listeners {
+		if l.protocol == protocol {
+			return l, true
+		}
+	}
+	return clientListener{}, false
+}
+
+func curlSnippet(listeners []clientListener) string {
+	var b strings.Builder
+	for _, l := range listeners {
+		fmt.Fprintf(&b, "# %s\ncurl -x %s https://icanhazip.com/\n", l.name, shellQuote(l.proxyURL()))
+	}
+	return b.String()
+}
+
+// proxychainsSnippet proxychains的ProxyList为依次经过的代理链，只使用一个监听，优先socks5
+func proxychainsSnippet(listeners []clientListener) string {
+	l, ok := firstListener(listeners, config.ProtocolSocks5)
+	if !ok {
+		l = listeners[0]
+	}
+	var b strings.Builder
+	b.WriteString("strict_chain\nproxy_dns\ntcp_read_time_out 15000\ntcp_connect_time_out 8000\n\n[ProxyList]\n")
+	fmt.Fprintf(&b, "%s %s %d", l.protocol, l.host, l.port)
+	if l.userName != "" {
+		fmt.Fprintf(&b, " %s %s", l.userName, l.password)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// clashSnippet Clash配置，全部监听放入一个策略组，规则由[pac]转换
+func clashSnippet(listeners []clientListener, pac config.PACConfig) string {
+	const group = "proxy_harvester"
+	var b strings.Builder
+	b.WriteString("proxies:\n")
+	names := make([]string, 0, len(listeners))
+	for _, l := range listeners {
+		name := group + "-" + l.name
+		names = append(names, strconv.Quote(name))
+		fmt.Fprintf(&b, "  - name: %q\n    type: %s\n    server: %s\n    port: %d\n", name, l.protocol, l.host, l.port)
+		if l.userName != "" {
+			fmt.Fprintf(&b, "    username: %q\n    password: %q\n", l.userName, l.password)
+		}
+		if l.protocol == config.ProtocolSocks5 {
+			fmt.Fprintf(&b, "    udp: %t\n", l.udp)
+		}
+	}
+	fmt.Fprintf(&b, "\nproxy-groups:\n  - name: %s\n    type: select\n    proxies: [%s]\n\nrules:\n", group, strings.Join(names, ", "))
+	for _, rule := range pac.Direct {
+		if r := clashRule(rule, "DIRECT"); r != "" {
+			fmt.Fprintf(&b, "  - %s\n", r)
+		}
+	}
+	for _, rule := range pac.Proxy {
+		if r := clashRule(rule, group); r != "" {
+			fmt.Fprintf(&b, "  - %s\n", r)
+		}
+	}
+	if strings.ToLower(pac.Default) == "direct" {
+		b.WriteString("  - MATCH,DIRECT\n")
+	} else {
+		fmt.Fprintf(&b, "  - MATCH,%s\n", group)
+	}
+	return b.String()
+}
+
+// clashRule 将PAC规则转为Clash规则，无法转换的通配符规则返回空
+func clashRule(rule, target string) string {
+	switch {
+	case strings.Contains(rule, "/"):
+		return "IP-CIDR," + rule + "," + target + ",no-resolve"
+	case strings.HasPrefix(rule, "*.") && !strings.Contains(rule[2:], "*"):
+		return "DOMAIN-SUFFIX," + rule[2:] + "," + target
+	case !strings.Contains(rule, "*"):
+		return "DOMAIN," + rule + "," + target
+	}
+	return ""
+}
+
+// envSnippet 环境变量，http_proxy/https_proxy使用HTTP监听，all_proxy使用SOCKS5监听，no_proxy由direct规则转换
+func envSnippet(listeners []clientListener, pac config.PACConfig) string {
+	var b strings.Builder
+	if l, ok := firstListener(listeners, config.ProtocolHTTP); ok {
+		fmt.Fprintf(&b, "export http_proxy=%s\nexport https_proxy=%s\n", shellQuote(l.proxyURL()), shellQuote(l.proxyURL()))
+	}
+	if l, ok := firstListener(listeners, config.ProtocolSocks5); ok {
+		fmt.Fprintf(&b, "export all_proxy=%s\n", shellQuote(l.proxyURL()))
+	}
+	noProxy := []string{"localhost", "127.0.0.1", "::1"}
+	for _, rule := range pac.Direct {
+		if strings.HasPrefix(rule, "*.") && !strings.Contains(rule[2:], "*") {
+			noProxy = append(noProxy, rule[1:])
+		} else if !strings.Contains(rule, "*") {
+			noProxy = append(noProxy, rule)
+		}
+	}
+	fmt.Fprintf(&b, "export no_proxy=%s\n", shellQuote(strings.Join(noProxy, ",")))
+	return b.String()
+}
+
+// shellQuote 用单引号包裹字符串供shell使用，字符串中的单引号先闭合引号再以反斜杠转义
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
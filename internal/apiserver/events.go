@@ -0,0 +1,207 @@
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/overflow0verture/proxy_harvester/internal/event"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// defaultHeartbeat 推送流默认的心跳间隔（秒）
+	defaultHeartbeat = 15
+	// streamBuffer 每个推送流的事件缓冲，客户端消费过慢时超出的事件被丢弃
+	streamBuffer = 256
+	// streamWriteTimeout 推送流单次写入的超时
+	streamWriteTimeout = 10 * time.Second
+)
+
+// 推送流中由API生成的事件类型
+const (
+	streamHeartbeat = "heartbeat" // 心跳，data.seq为最近发布的事件序号
+	streamGap       = "gap"       // 请求恢复的事件已有部分不再保留
+)
+
+// streamOptions 推送流的订阅参数
+type streamOptions struct {
+	types     []string
+	heartbeat time.Duration
+	since     uint64
+	resume    bool // 是否指定了since或Last-Event-ID
+}
+
+// parseStreamOptions 解析事件类型、心跳间隔和恢复序号，恢复序号优先取since参数，其次取Last-Event-ID请求头
+func parseStreamOptions(r *http.Request) (streamOptions, error) {
+	q := r.URL.Query()
+	var opts streamOptions
+	for _, typ := range strings.Split(q.Get("types"), ",") {
+		if typ = strings.TrimSpace(typ); typ == "" {
+			continue
+		}
+		if !matchAnyType(typ) {
+			return opts, fmt.Errorf("types参数无效，可选%s", strings.Join(event.Types, "、"))
+		}
+		opts.types = append(opts.types, typ)
+	}
+
+	heartbeat, err := intParam(q, "heartbeat", defaultHeartbeat, 1, 300)
+	if err != nil {
+		return opts, errors.New("heartbeat参数无效，必须是1-300之间的整数（秒）")
+	}
+	opts.heartbeat = time.Duration(heartbeat) * time.Second
+
+	since := q.Get("since")
+	if since == "" {
+		since = r.Header.Get("Last-Event-ID")
+	}
+	if since != "" {
+		opts.since, err = strconv.ParseUint(since, 10, 64)
+		if err != nil {
+			return opts, errors.New("since参数无效，必须是事件序号")
+		}
+		opts.resume = true
+	}
+	return opts, nil
+}
+
+func matchAnyType(typ string) bool {
+	for _, t := range event.Types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// handleEvents 推送事件流，请求带有WebSocket升级头时使用WebSocket，否则使用Server-Sent Events
+func (s *APIServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, 405, "只支持GET方法")
+		return
+	}
+	if !s.adminOnly(w, r) {
+		return
+	}
+	opts, err := parseStreamOptions(r)
+	if err != nil {
+		s.writeError(w, 400, err.Error())
+		return
+	}
+
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		server := websocket.Server{
+			// 非浏览器客户端通常不带Origin，认证已由token完成
+			Handshake: func(*websocket.Config, *http.Request) error { return nil },
+			Handler: func(ws *websocket.Conn) {
+				s.serveWebSocketEvents(ws, opts)
+			},
+		}
+		server.ServeHTTP(w, r)
+		return
+	}
+	s.serveSSEEvents(w, r, opts)
+}
+
+// serveSSEEvents 以Server-Sent Events推送，每个事件的id为序号，断线重连时浏览器自动带上Last-Event-ID
+func (s *APIServer) serveSSEEvents(w http.ResponseWriter, r *http.Request, opts streamOptions) {
+	rc := http.NewResponseController(w)
+	// 推送流长期保持，不受服务器写超时限制
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	s.streamEvents(r.Context(), opts, func(ev event.Event) error {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if ev.Seq > 0 {
+			fmt.Fprintf(w, "id: %d\n", ev.Seq)
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	})
+}
+
+// serveWebSocketEvents 以WebSocket推送，每条文本消息为一个JSON事件，客户端发送的消息被忽略
+func (s *APIServer) serveWebSocketEvents(ws *websocket.Conn, opts streamOptions) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// 读到错误（客户端关闭连接）时结束推送
+	ws.SetReadDeadline(time.Time{})
+	go func() {
+		defer cancel()
+		var discard string
+		for websocket.Message.Receive(ws, &discard) == nil {
+		}
+	}()
+
+	s.streamEvents(ctx, opts, func(ev event.Event) error {
+		ws.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		return websocket.JSON.Send(ws, ev)
+	})
+}
+
+// streamEvents 订阅事件总线并逐个发送，指定恢复序号时先发送保留的后续事件
+// 客户端断开、发送失败或API服务器关闭时返回
+func (s *APIServer) streamEvents(ctx context.Context, opts streamOptions, send func(event.Event) error) {
+	var backlog []event.Event
+	var missed bool
+	var ch <-chan event.Event
+	var cancel func()
+	if opts.resume {
+		backlog, missed, ch, cancel = event.SubscribeSince(opts.since, streamBuffer, opts.types...)
+	} else {
+		ch, cancel = event.Subscribe(streamBuffer, opts.types...)
+	}
+	defer cancel()
+
+	if missed {
+		gap := event.Event{Type: streamGap, Time: time.Now(), Data: map[string]uint64{"since": opts.since}}
+		if err := send(gap); err != nil {
+			return
+		}
+	}
+	for _, ev := range backlog {
+		if err := send(ev); err != nil {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(opts.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := send(ev); err != nil {
+				return
+			}
+		case <-ticker.C:
+			heartbeat := event.Event{Type: streamHeartbeat, Time: time.Now(), Data: map[string]uint64{"seq": event.LastSeq()}}
+			if err := send(heartbeat); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		case <-s.streamsDone:
+			return
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/overflow0verture/proxy_harvester/internal/errclass"
+	"github.com/overflow0verture/proxy_harvester/internal/pool"
+	"github.com/overflow0verture/proxy_harvester/internal/user"
+)
+
+// adminReporter 使用管理令牌反馈时的反馈方名称
+const adminReporter = "admin"
+
+// feedbackRequest 客户端反馈的请求体
+type feedbackRequest struct {
+	Proxy   string `json:"proxy"`
+	Success bool   `json:"success"`
+	Domain  string `json:"domain"`
+	Class   string `json:"class"`
+}
+
+// handleFeedback 客户端反馈从/api/proxies获取的代理的使用结果，按令牌的权重计入健康分和隔离
+// 管理令牌权重为1，用户令牌使用用户配置的feedback_weight
+func (s *APIServer) handleFeedback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, 405, "只支持POST方法")
+		return
+	}
+
+	var req feedbackRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&req); err != nil {
+		s.writeError(w, 400, "请求体不是有效的JSON")
+		return
+	}
+	if req.Proxy == "" {
+		s.writeError(w, 400, "缺少proxy字段")
+		return
+	}
+	class, ok := errclass.Parse(req.Class)
+	if !ok {
+		s.writeError(w, 400, "class无效: "+req.Class)
+		return
+	}
+	if req.Success {
+		class = errclass.None
+	}
+	found, err := s.inPool(req.Proxy)
+	if err != nil {
+		s.writeError(w, 500, "获取代理池失败")
+		return
+	}
+	if !found {
+		s.writeError(w, 404, "代理不在代理池中")
+		return
+	}
+
+	reporter, weight := adminReporter, 1.0
+	if acct := user.FromContext(r.Context()); acct != nil {
+		reporter = acct.Name()
+		weight = acct.User().FeedbackWeight
+		if weight == 0 {
+			weight = pool.DefaultFeedbackWeight
+		}
+	}
+	result := pool.ReportFeedback(pool.Feedback{
+		Proxy:    req.Proxy,
+		Success:  req.Success,
+		Domain:   req.Domain,
+		Class:    class,
+		Reporter: reporter,
+	}, weight)
+
+	message := "已记录"
+	if result.Weight == 0 {
+		message = "已忽略（重复反馈或该令牌的反馈不计入）"
+	}
+	s.writeJSON(w, map[string]interface{}{
+		"code":    200,
+		"message": message,
+		"data":    result,
+	})
+}
@@ -0,0 +1,177 @@
+package apiserver
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/overflow0verture/proxy_harvester/internal/access"
+	"github.com/overflow0verture/proxy_harvester/internal/globals"
+	"github.com/overflow0verture/proxy_harvester/internal/metrics"
+	"github.com/overflow0verture/proxy_harvester/internal/pool"
+)
+
+// 代理在代理池中的状态
+const (
+	stateActive      = "active"
+	stateQuarantined = "quarantined"
+)
+
+var apiRequestDuration = metrics.NewHistogramVec("api_request_duration_seconds", "API请求耗时", nil, "path", "method", "code")
+
+// statusRecorder 记录响应状态码
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap 供http.ResponseController访问底层的ResponseWriter
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Hijack WebSocket需要接管连接
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	r.status = http.StatusSwitchingProtocols
+	return http.NewResponseController(r.ResponseWriter).Hijack()
+}
+
+// Flush 流式响应需要及时发送
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// routeLabel 请求对应的路由，未注册的路径统一记为other，避免标签数量随请求路径增长
+func routeLabel(mux *http.ServeMux, r *http.Request) string {
+	if _, pattern := mux.Handler(r); pattern != "" {
+		return pattern
+	}
+	return "other"
+}
+
+// registerMetrics 注册抓取时从代理池、检测队列和访问统计读取的指标
+func (s *APIServer) registerMetrics() {
+	metrics.RegisterGaugeFunc("pool_proxies", "代理池中的代理数，按协议、国家/地区和状态区分",
+		[]string{"protocol", "country", "state"}, s.poolSamples)
+	metrics.RegisterGaugeFunc("check_queue_depth", "等待检测的代理数", nil, func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(len(globals.ToCheckChan))}}
+	})
+	metrics.RegisterGaugeFunc("check_queue_capacity", "检测队列的容量", nil, func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(cap(globals.ToCheckChan))}}
+	})
+
+	metrics.RegisterGaugeFunc("listener_active_connections", "本地监听当前转发中的连接数", []string{"listener"},
+		func() []metrics.Sample {
+			return listenerSamples(func(c access.Counters) float64 { return float64(c.Active) })
+		})
+	metrics.RegisterCounterFunc("listener_connections_total", "本地监听已结束的连接数（含连接上游失败）", []string{"listener"},
+		func() []metrics.Sample {
+			return listenerSamples(func(c access.Counters) float64 { return float64(c.Connections) })
+		})
+	metrics.RegisterCounterFunc("listener_upstream_failures_total", "本地监听连接上游失败的次数", []string{"listener"},
+		func() []metrics.Sample {
+			return listenerSamples(func(c access.Counters) float64 { return float64(c.Failed) })
+		})
+	metrics.RegisterCounterFunc("relayed_bytes_total", "本地监听已结束的连接转发的字节数，up为客户端发往目标", []string{"listener", "direction"},
+		func() []metrics.Sample {
+			listeners := access.Snapshot().Listeners
+			samples := make([]metrics.Sample, 0, 2*len(listeners))
+			for _, name := range sortedListeners(listeners) {
+				c := listeners[name]
+				samples = append(samples,
+					metrics.Sample{Values: []string{name, "up"}, Value: float64(c.BytesUp)},
+					metrics.Sample{Values: []string{name, "down"}, Value: float64(c.BytesDown)})
+			}
+			return samples
+		})
+}
+
+// poolSamples 按协议、国家/地区和状态统计代理池
+func (s *APIServer) poolSamples() []metrics.Sample {
+	proxies, err := s.proxyStore.GetAll()
+	if err != nil {
+		return nil
+	}
+	counts := make(map[[3]string]int)
+	for _, proxy := range proxies {
+		protocol, _, _ := strings.Cut(proxy, "://")
+		meta, _ := pool.GetMeta(proxy)
+		country := meta.Country
+		if country == "" {
+			country = "unknown"
+		}
+		state := stateActive
+		if pool.IsQuarantined(proxy) {
+			state = stateQuarantined
+		}
+		counts[[3]string{protocol, country, state}]++
+	}
+
+	samples := make([]metrics.Sample, 0, len(counts))
+	for key, n := range counts {
+		samples = append(samples, metrics.Sample{Values: []string{key[0], key[1], key[2]}, Value: float64(n)})
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].Values, "|") < strings.Join(samples[j].Values, "|")
+	})
+	return samples
+}
+
+// listenerSamples 按监听取访问统计中的一项计数
+func listenerSamples(value func(access.Counters) float64) []metrics.Sample {
+	listeners := access.Snapshot().Listeners
+	samples := make([]metrics.Sample, 0, len(listeners))
+	for _, name := range sortedListeners(listeners) {
+		samples = append(samples, metrics.Sample{Values: []string{name}, Value: value(listeners[name])})
+	}
+	return samples
+}
+
+func sortedListeners(listeners map[string]access.Counters) []string {
+	names := make([]string, 0, len(listeners))
+	for name := range listeners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// handleMetrics 以Prometheus文本格式输出指标，需要管理令牌
+func (s *APIServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, 405, "只支持GET方法")
+		return
+	}
+	if !s.adminOnly(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.WriteText(w)
+}
+
+// statusLabel 状态码标签
+func statusLabel(status int) string {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return strconv.Itoa(status)
+}
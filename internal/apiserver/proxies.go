@@ -0,0 +1,167 @@
+package apiserver
+
+import (
+	"errors"
+	"math/rand"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/overflow0verture/proxy_harvester/internal/pool"
+)
+
+// /api/proxies 的排序方式
+const (
+	orderRandom = "random" // 随机（默认）
+	orderScore  = "score"  // 健康分从高到低，相同时延迟低的在前
+)
+
+// anonymityRank 匿名级别由低到高的排序，未探测为0
+var anonymityRank = map[string]int{
+	pool.AnonymityTransparent: 1,
+	pool.AnonymityAnonymous:   2,
+	pool.AnonymityElite:       3,
+}
+
+// proxyQuery /api/proxies 的筛选条件，字段为空表示不限
+type proxyQuery struct {
+	filter     pool.Filter
+	asn        string
+	source     string
+	maxLatency int64 // 毫秒
+	anonymity  int   // 最低匿名级别
+	maxAge     time.Duration
+	order      string
+}
+
+// parseProxyQuery 解析/api/proxies的筛选和排序参数
+func parseProxyQuery(q url.Values) (proxyQuery, error) {
+	pq := proxyQuery{
+		filter: filterFromQuery(q),
+		asn:    normalizeASN(q.Get("asn")),
+		source: q.Get("source"),
+		order:  q.Get("order"),
+	}
+	// type为原有参数，与proto相同
+	if pq.filter.Protocol == "" {
+		pq.filter.Protocol = q.Get("type")
+	}
+	for _, label := range strings.Split(q.Get("labels"), ",") {
+		if label = strings.TrimSpace(label); label != "" {
+			pq.filter.Labels = append(pq.filter.Labels, label)
+		}
+	}
+
+	maxLatency, err := intParam(q, "max_latency", 0, 1, 1<<30)
+	if err != nil {
+		return pq, errors.New("max_latency参数无效，必须是正整数（毫秒）")
+	}
+	pq.maxLatency = int64(maxLatency)
+	maxAge, err := intParam(q, "max_age", 0, 1, 1<<30)
+	if err != nil {
+		return pq, errors.New("max_age参数无效，必须是正整数（秒）")
+	}
+	pq.maxAge = time.Duration(maxAge) * time.Second
+
+	if level := q.Get("anonymity"); level != "" {
+		rank, ok := anonymityRank[level]
+		if !ok {
+			return pq, errors.New("anonymity参数无效，可选transparent、anonymous、elite")
+		}
+		pq.anonymity = rank
+	}
+	switch pq.order {
+	case "":
+		pq.order = orderRandom
+	case orderRandom, orderScore:
+	default:
+		return pq, errors.New("order参数无效，可选random、score")
+	}
+	return pq, nil
+}
+
+// normalizeASN 统一自治系统号的格式，AS13335、13335和"AS13335 Cloudflare, Inc."视为相同
+func normalizeASN(asn string) string {
+	asn, _, _ = strings.Cut(strings.TrimSpace(asn), " ")
+	if len(asn) > 2 && strings.EqualFold(asn[:2], "AS") {
+		asn = asn[2:]
+	}
+	return asn
+}
+
+// latencyMs 代理的延迟，优先使用本地监听实际拨号的滑动平均值，没有时使用检测耗时，都没有时为0
+func latencyMs(meta pool.ProxyMeta) int64 {
+	if meta.LatencyMs > 0 {
+		return meta.LatencyMs
+	}
+	return meta.CheckLatencyMs
+}
+
+// match 判断代理是否满足筛选条件
+// 隔离或屏蔽中的代理不返回；指定延迟、匿名级别或检测时间条件时，缺少对应信息的代理不返回
+func (pq proxyQuery) match(info ProxyInfo) bool {
+	if info.Quarantined || pool.IsBanned(info.Proxy) || !pq.filter.Match(info.Proxy) {
+		return false
+	}
+	if pq.asn != "" && normalizeASN(info.ASN) != pq.asn {
+		return false
+	}
+	if pq.source != "" && info.Source != pq.source {
+		return false
+	}
+	if pq.maxLatency > 0 {
+		if latency := latencyMs(info.ProxyMeta); latency == 0 || latency > pq.maxLatency {
+			return false
+		}
+	}
+	if pq.anonymity > 0 && anonymityRank[info.Anonymity] < pq.anonymity {
+		return false
+	}
+	if pq.maxAge > 0 && (info.VerifiedAt.IsZero() || time.Since(info.VerifiedAt) > pq.maxAge) {
+		return false
+	}
+	return true
+}
+
+// sortProxies 按排序方式排列筛选结果
+func (pq proxyQuery) sortProxies(items []ProxyInfo) {
+	if pq.order == orderRandom {
+		rand.Shuffle(len(items), func(i, j int) {
+			items[i], items[j] = items[j], items[i]
+		})
+		return
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].Score != items[j].Score {
+			return items[i].Score > items[j].Score
+		}
+		// 延迟未知的排在后面
+		li, lj := latencyMs(items[i].ProxyMeta), latencyMs(items[j].ProxyMeta)
+		if li == 0 || lj == 0 {
+			return li != 0
+		}
+		return li < lj
+	})
+}
+
+// matchedProxies 从代理池全量列表中筛选并排序，不经过GetNext，不影响监听的轮询位置和限速
+func (s *APIServer) matchedProxies(pq proxyQuery) ([]string, int, error) {
+	proxies, err := s.proxyStore.GetAll()
+	if err != nil {
+		return nil, 0, err
+	}
+	items := make([]ProxyInfo, 0, len(proxies))
+	for _, proxy := range proxies {
+		if info := newProxyInfo(proxy); pq.match(info) {
+			items = append(items, info)
+		}
+	}
+	pq.sortProxies(items)
+
+	result := make([]string, 0, len(items))
+	for _, info := range items {
+		result = append(result, info.Proxy)
+	}
+	return result, len(proxies), nil
+}
@@ -0,0 +1,79 @@
+package apiserver
+
+import (
+	"net/http"
+
+	"github.com/overflow0verture/proxy_harvester/internal/pool"
+)
+
+// handleSessions 粘性会话租约管理，租约中包含其他用户的上游代理，需要管理令牌
+// GET 查询租约列表（或指定session），POST 创建/获取租约，DELETE 撤销租约
+func (s *APIServer) handleSessions(w http.ResponseWriter, r *http.Request) {
+	if !s.adminOnly(w, r) {
+		return
+	}
+	q := r.URL.Query()
+	session := q.Get("session")
+
+	switch r.Method {
+	case http.MethodGet:
+		if session != "" {
+			lease, ok := pool.GetSession(session)
+			if !ok {
+				s.writeError(w, 404, "会话不存在或已过期")
+				return
+			}
+			s.writeJSON(w, map[string]interface{}{
+				"code":    200,
+				"message": "获取成功",
+				"data":    lease,
+			})
+			return
+		}
+		leases := pool.Sessions()
+		s.writeJSON(w, map[string]interface{}{
+			"code":    200,
+			"message": "获取成功",
+			"data":    leases,
+			"count":   len(leases),
+		})
+
+	case http.MethodPost:
+		if session == "" {
+			s.writeError(w, 400, "缺少session参数")
+			return
+		}
+		filter := pool.Filter{
+			Country:  q.Get("country"),
+			Protocol: q.Get("proto"),
+			Label:    q.Get("label"),
+		}
+		if _, err := pool.SelectSticky(s.proxyStore, filter, session); err != nil {
+			s.writeError(w, 404, "没有满足条件的代理")
+			return
+		}
+		lease, _ := pool.GetSession(session)
+		s.writeJSON(w, map[string]interface{}{
+			"code":    200,
+			"message": "获取成功",
+			"data":    lease,
+		})
+
+	case http.MethodDelete:
+		if session == "" {
+			s.writeError(w, 400, "缺少session参数")
+			return
+		}
+		if err := pool.RevokeSession(session); err != nil {
+			s.writeError(w, 404, err.Error())
+			return
+		}
+		s.writeJSON(w, map[string]interface{}{
+			"code":    200,
+			"message": "撤销成功",
+		})
+
+	default:
+		s.writeError(w, 405, "只支持GET、POST、DELETE方法")
+	}
+}
@@ -0,0 +1,78 @@
+package apiserver
+
+import (
+	"net/http"
+
+	"github.com/overflow0verture/proxy_harvester/internal/user"
+)
+
+// UserInfo 用户配额及使用量，不包含密码和令牌
+type UserInfo struct {
+	Name                string     `json:"name"`
+	MaxConns            int        `json:"max_conns"`
+	BandwidthKBps       int        `json:"bandwidth_kbps"`
+	DailyRequests       int64      `json:"daily_requests"`
+	DailyTrafficMB      int64      `json:"daily_traffic_mb"`
+	AllowedDestinations []string   `json:"allowed_destinations"`
+	AllowedPools        []string   `json:"allowed_pools"`
+	RaceDials           int        `json:"race_dials"`
+	Usage               user.Usage `json:"usage"`
+}
+
+func newUserInfo(acct *user.Account) UserInfo {
+	u := acct.User()
+	return UserInfo{
+		Name:                u.Name,
+		MaxConns:            u.MaxConns,
+		BandwidthKBps:       u.BandwidthKBps,
+		DailyRequests:       u.DailyRequests,
+		DailyTrafficMB:      u.DailyTrafficMB,
+		AllowedDestinations: u.AllowedDestinations,
+		AllowedPools:        u.AllowedPools,
+		RaceDials:           u.RaceDials,
+		Usage:               acct.Usage(),
+	}
+}
+
+// handleGetUsers 查询用户配额及使用量
+// 使用管理令牌时返回全部用户（可按name筛选），使用用户令牌时只返回自己
+func (s *APIServer) handleGetUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, 405, "只支持GET方法")
+		return
+	}
+	if !user.Enabled() {
+		s.writeError(w, 404, "未开启多用户")
+		return
+	}
+
+	if acct := user.FromContext(r.Context()); acct != nil {
+		s.writeJSON(w, map[string]interface{}{
+			"code":    200,
+			"message": "获取成功",
+			"data":    []UserInfo{newUserInfo(acct)},
+			"count":   1,
+		})
+		return
+	}
+
+	accounts, err := user.Accounts()
+	if err != nil {
+		s.writeError(w, 500, "获取用户失败: "+err.Error())
+		return
+	}
+	name := r.URL.Query().Get("name")
+	infos := make([]UserInfo, 0, len(accounts))
+	for _, acct := range accounts {
+		if name != "" && acct.Name() != name {
+			continue
+		}
+		infos = append(infos, newUserInfo(acct))
+	}
+	s.writeJSON(w, map[string]interface{}{
+		"code":    200,
+		"message": "获取成功",
+		"data":    infos,
+		"count":   len(infos),
+	})
+}
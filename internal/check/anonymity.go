@@ -0,0 +1,103 @@
+package check
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/overflow0verture/proxy_harvester/internal/config"
+	"github.com/overflow0verture/proxy_harvester/internal/pool"
+)
+
+// localIPRefresh 本机出口IP的刷新间隔
+const localIPRefresh = 10 * time.Minute
+
+// proxyHeaders 代理常添加的请求头，判定地址返回其中任一即说明目标可以察觉使用了代理
+var proxyHeaders = []string{"via", "x-forwarded-for", "forwarded", "x-real-ip", "proxy-connection", "x-proxy-id", "client-ip", "x-client-ip"}
+
+// anonymityProbe 通过代理访问返回请求头的判定地址，判断代理的匿名级别
+type anonymityProbe struct {
+	judgeURL string
+	ipURL    string
+
+	mu      sync.Mutex
+	localIP string
+	fetched time.Time
+}
+
+// newAnonymityProbe 根据配置创建匿名度探测器，未开启时返回nil
+func newAnonymityProbe(cfg config.CheckAnonymityConfig) *anonymityProbe {
+	if cfg.Switch != "open" {
+		return nil
+	}
+	probe := &anonymityProbe{judgeURL: cfg.JudgeURL, ipURL: cfg.IPURL}
+	if probe.judgeURL == "" {
+		probe.judgeURL = "http://httpbin.org/headers"
+	}
+	if probe.ipURL == "" {
+		probe.ipURL = "http://api.ipify.org"
+	}
+	return probe
+}
+
+// level 通过代理访问判定地址，响应中出现本机IP为透明代理，出现代理相关请求头为普通匿名，否则为高匿
+func (p *anonymityProbe) level(probes checkProbes, proxyAddr string, timeout time.Duration) (string, error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return probes.dial(ctx, proxyAddr, network, addr, timeout)
+			},
+		},
+		Timeout: timeout,
+	}
+	resp, err := client.Get(p.judgeURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return "", err
+	}
+
+	if ip := p.realIP(timeout); ip != "" && strings.Contains(string(body), ip) {
+		return pool.AnonymityTransparent, nil
+	}
+	// 判定地址可能以JSON（"Via": ...）或CGI环境变量（HTTP_VIA = ...）的形式返回请求头
+	text := strings.ReplaceAll(strings.ToLower(string(body)), "_", "-")
+	for _, h := range proxyHeaders {
+		if strings.Contains(text, `"`+h+`"`) || strings.Contains(text, "http-"+h) {
+			return pool.AnonymityAnonymous, nil
+		}
+	}
+	return pool.AnonymityElite, nil
+}
+
+// realIP 本机出口IP，定期直接访问ipURL刷新，获取失败时沿用上次的结果
+func (p *anonymityProbe) realIP(timeout time.Duration) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if time.Since(p.fetched) < localIPRefresh {
+		return p.localIP
+	}
+	p.fetched = time.Now()
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(p.ipURL)
+	if err != nil {
+		return p.localIP
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return p.localIP
+	}
+	if ip := net.ParseIP(strings.TrimSpace(string(body))); ip != nil {
+		p.localIP = ip.String()
+	}
+	return p.localIP
+}
@@ -1,29 +1,72 @@
 package check
 
 import (
-	"github.com/overflow0verture/proxy_harvester/internal/config"
-	"github.com/overflow0verture/proxy_harvester/internal/globals"
-	"github.com/overflow0verture/proxy_harvester/internal/logger"
-	"github.com/overflow0verture/proxy_harvester/internal/pool"
 	"context"
 	"crypto/tls"
-	"golang.org/x/net/proxy"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
-	"net/url"
+	"net/http/httptrace"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/overflow0verture/proxy_harvester/internal/config"
+	"github.com/overflow0verture/proxy_harvester/internal/errclass"
+	"github.com/overflow0verture/proxy_harvester/internal/event"
+	"github.com/overflow0verture/proxy_harvester/internal/globals"
+	"github.com/overflow0verture/proxy_harvester/internal/logger"
+	"github.com/overflow0verture/proxy_harvester/internal/metrics"
+	"github.com/overflow0verture/proxy_harvester/internal/pool"
 )
 
-type checkJob struct {
-	Proxy string
+// 检测配置方案
+const (
+	ProfileDefault   = "default"   // 通过checkURL+关键字检测
+	ProfileGeolocate = "geolocate" // 通过归属地接口+关键字检测
+)
+
+// 检测进行到的阶段
+const (
+	StageParse     = "parse"     // 解析代理地址
+	StageDial      = "dial"      // 连接代理/建立隧道
+	StageRequest   = "request"   // 已建立连接，等待响应
+	StageRead      = "read"      // 读取响应体
+	StageVerify    = "verify"    // 校验响应内容
+	StageTLS       = "tls"       // TLS中间人检测
+	StageUDP       = "udp"       // UDP能力探测
+	StageAnonymity = "anonymity" // 匿名度探测
+	StagePassed    = "passed"    // 检测通过
+)
+
+// CheckEvent 单次代理检测的结构化结果，会发布到事件总线并写入审计日志
+type CheckEvent struct {
+	Proxy      string         `json:"proxy"`
+	Source     string         `json:"source,omitempty"`
+	Profile    string         `json:"profile"`
+	Stage      string         `json:"stage"`
+	Alive      bool           `json:"alive"`
+	LatencyMs  int64          `json:"latency_ms"`
+	ErrorClass errclass.Class `json:"error_class,omitempty"`
+	// TLSIntercepted 代理返回的证书链与预期不符（仅开启checkTLS时有效）
+	TLSIntercepted bool `json:"tls_intercepted,omitempty"`
+	// UDP socks5代理支持UDP转发（仅开启checkUDP时有效）
+	UDP bool `json:"udp,omitempty"`
+	// Country 归属地接口返回的国家/地区（仅配置countryField时有效）
+	Country string `json:"country,omitempty"`
+	// ASN 归属地接口返回的自治系统号（仅配置asnField时有效）
+	ASN string `json:"asn,omitempty"`
+	// Anonymity 匿名级别（仅开启checkAnonymity时有效）
+	Anonymity string    `json:"anonymity,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
 }
 
-type checkResult struct {
+type checkJob struct {
 	Proxy string
-	Alive bool
 }
 
 // Worker pool高并发检测
@@ -43,9 +86,10 @@ func CheckSocks(checkSocks config.CheckSocksConfig, socksListParam []string, pro
 	}
 
 	logger.Info("开始批量检测代理，并发: %v, 超时标准: %vs", maxWorkers, timeout)
+	probes := loadProbes(checkSocks, proxyStore)
 
 	jobs := make(chan checkJob, len(socksListParam))
-	results := make(chan checkResult, len(socksListParam))
+	results := make(chan CheckEvent, len(socksListParam))
 
 	// 启动worker
 	var wg sync.WaitGroup
@@ -54,8 +98,7 @@ func CheckSocks(checkSocks config.CheckSocksConfig, socksListParam []string, pro
 		go func() {
 			defer wg.Done()
 			for job := range jobs {
-				alive := checkProxyAlive(job.Proxy, reqUrl, timeout, checkRspKeywords, isOpenGeolocateSwitch, checkGeolocateConfig)
-				results <- checkResult{Proxy: job.Proxy, Alive: alive}
+				results <- checkProxyAlive(job.Proxy, reqUrl, timeout, checkRspKeywords, isOpenGeolocateSwitch, checkGeolocateConfig, probes)
 			}
 		}()
 	}
@@ -72,13 +115,17 @@ func CheckSocks(checkSocks config.CheckSocksConfig, socksListParam []string, pro
 		res := <-results
 		if res.Alive {
 			proxyStore.Add(res.Proxy)
+			pool.HandleSuccess(res.Proxy)
 			valid++
 		} else {
-			proxyStore.MarkInvalid(res.Proxy)
+			pool.HandleFailure(proxyStore, res.Proxy, res.ErrorClass)
 		}
 	}
 
 	wg.Wait()
+	if n := pool.PruneMetas(proxyStore); n > 0 {
+		logger.Info("清理 %d 个已离开代理池的代理附加信息", n)
+	}
 
 	cnt, _ := proxyStore.Len()
 	sec := int(time.Since(startTime).Seconds())
@@ -88,103 +135,270 @@ func CheckSocks(checkSocks config.CheckSocksConfig, socksListParam []string, pro
 	logger.Info("批量检测完成，用时 %vs，发现 %v 个可用代理，总代理池数量: %v", sec, valid, cnt)
 }
 
-// 检测单个代理是否可用，支持socks5/http/https认证代理
-func checkProxyAlive(proxyAddr, reqUrl string, timeout int, checkRspKeywords string, isOpenGeolocateSwitch bool, checkGeolocateConfig config.CheckGeolocateConfig) bool {
-	var client *http.Client
-	var transport *http.Transport
-
-	if strings.HasPrefix(proxyAddr, "socks5://") {
-		u, err := url.Parse(proxyAddr)
-		if err != nil {
-			return false
-		}
-		host := u.Host
-		var auth *proxy.Auth
-		if u.User != nil {
-			user := u.User.Username()
-			pass, _ := u.User.Password()
-			auth = &proxy.Auth{User: user, Password: pass}
-		}
-		dialer := &net.Dialer{Timeout: time.Duration(timeout) * time.Second}
-		socksDialer, err := proxy.SOCKS5("tcp", host, auth, dialer)
-		if err != nil {
-			return false
+// supportedScheme 是否为检测支持的代理类型
+func supportedScheme(proxyAddr string) bool {
+	for _, prefix := range []string{"socks5://", "socks4://", "socks4a://", "http://", "https://"} {
+		if strings.HasPrefix(proxyAddr, prefix) {
+			return true
 		}
-		tr := &http.Transport{
+	}
+	return false
+}
+
+// 检测单个代理是否可用，支持socks5/socks4/socks4a/http/https代理，socks5和http/https支持认证
+// 返回的CheckEvent已发布到事件总线
+// 主检测通过后按probes执行TLS中间人检测和UDP能力探测
+func checkProxyAlive(proxyAddr, reqUrl string, timeout int, checkRspKeywords string, isOpenGeolocateSwitch bool, checkGeolocateConfig config.CheckGeolocateConfig, probes checkProbes) CheckEvent {
+	ev := CheckEvent{
+		Proxy:     proxyAddr,
+		Profile:   ProfileDefault,
+		Stage:     StageParse,
+		Timestamp: time.Now(),
+	}
+	if isOpenGeolocateSwitch {
+		ev.Profile = ProfileGeolocate
+	}
+	start := time.Now()
+	defer func() {
+		ev.LatencyMs = time.Since(start).Milliseconds()
+		checksTotal.Inc(sourceLabel(ev.Source), checkResult(ev.Alive))
+		event.Publish(event.TypeCheck, ev)
+	}()
+
+	if !supportedScheme(proxyAddr) {
+		ev.fail(errclass.Unsupported, errors.New("未知代理类型"))
+		return ev
+	}
+	if meta, ok := pool.GetMeta(proxyAddr); ok {
+		ev.Source = meta.Source
+	}
+
+	// 统一通过netutil建立隧道，与本地监听实际使用代理的方式保持一致
+	timeoutDur := time.Duration(timeout) * time.Second
+	client := &http.Client{
+		Transport: &http.Transport{
 			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-				return socksDialer.Dial(network, addr)
+				return probes.dial(ctx, proxyAddr, network, addr, timeoutDur)
 			},
 			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		}
-		client = &http.Client{
-			Transport: tr,
-			Timeout:   time.Duration(timeout) * time.Second,
-		}
-	} else if strings.HasPrefix(proxyAddr, "http://") || strings.HasPrefix(proxyAddr, "https://") {
-		proxyURL, err := url.Parse(proxyAddr)
-		if err != nil {
-			return false
-		}
-		transport = &http.Transport{
-			Proxy:           http.ProxyURL(proxyURL),
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		}
-		client = &http.Client{
-			Transport: transport,
-			Timeout:   time.Duration(timeout) * time.Second,
-		}
-	} else {
-		return false
+		},
+		Timeout: timeoutDur,
 	}
 
 	req, err := http.NewRequest("GET", reqUrl, nil)
 	if err != nil {
-		return false
+		ev.fail(errclass.Other, err)
+		return ev
 	}
 	req.Header.Add("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/112.0.0.0 Safari/537.36 Edg/112.0.1722.17")
 	req.Header.Add("referer", "https://www.baidu.com/s?ie=utf-8&f=8&rsv_bp=1&rsv_idx=1&tn=baidu&wd=ip&fenlei=256&rsv_pq=0xc23dafcc00076e78&rsv_t=6743gNBuwGYWrgBnSC7Yl62e52x3CKQWYiI10NeKs73cFjFpwmqJH%2FOI%2FSRG&rqlang=en&rsv_dl=tb&rsv_enter=1&rsv_sug3=5&rsv_sug1=5&rsv_sug7=101&rsv_sug2=0&rsv_btype=i&prefixsug=ip&rsp=4&inputT=2165&rsv_sug4=2719")
+
+	// 通过httptrace记录检测进行到的阶段
+	ev.Stage = StageDial
+	trace := &httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) {
+			ev.Stage = StageRequest
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
 	resp, err := client.Do(req)
 	if err != nil {
-		return false
+		ev.fail(errclass.Classify(err), err)
+		return ev
 	}
 	defer resp.Body.Close()
+	ev.Stage = StageRead
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return false
+		ev.fail(errclass.Classify(err), err)
+		return ev
 	}
+	// 检测耗时只计主检测请求，不含后续的TLS、UDP和匿名度探测
+	checkLatency := time.Since(start)
+	ev.Stage = StageVerify
 	stringBody := string(body)
 	if !isOpenGeolocateSwitch {
 		if !strings.Contains(stringBody, checkRspKeywords) {
-			return false
+			ev.fail(errclass.KeywordMismatch, errors.New("响应中未找到关键字: "+checkRspKeywords))
+			return ev
 		}
 	} else {
 		for _, keyword := range checkGeolocateConfig.ExcludeKeywords {
 			if strings.Contains(stringBody, keyword) {
-				return false
+				ev.fail(errclass.GeoExcluded, errors.New("归属地命中排除关键字: "+keyword))
+				return ev
 			}
 		}
 		for _, keyword := range checkGeolocateConfig.IncludeKeywords {
 			if !strings.Contains(stringBody, keyword) {
-				return false
+				ev.fail(errclass.GeoExcluded, errors.New("归属地缺少包含关键字: "+keyword))
+				return ev
+			}
+		}
+		// 记录归属地国家，供按国家选路使用
+		if checkGeolocateConfig.CountryField != "" {
+			if country := jsonField(body, checkGeolocateConfig.CountryField); country != "" {
+				ev.Country = country
+				pool.SetCountry(proxyAddr, country)
+			}
+		}
+		if checkGeolocateConfig.ASNField != "" {
+			if asn := jsonField(body, checkGeolocateConfig.ASNField); asn != "" {
+				ev.ASN = asn
+				pool.SetASN(proxyAddr, asn)
+			}
+		}
+	}
+
+	if probes.tls != nil {
+		ev.Stage = StageTLS
+		err := probes.tls.verify(probes, proxyAddr, timeoutDur)
+		if errors.Is(err, errTLSIntercepted) {
+			ev.TLSIntercepted = true
+			pool.SetTLSIntercepted(proxyAddr, true)
+			if probes.tls.action == TLSActionReject {
+				ev.fail(errclass.TLSFailure, err)
+				return ev
 			}
+			logger.Warning("%s 疑似TLS中间人代理，已标记", proxyAddr)
+		} else if err != nil {
+			ev.fail(errclass.Classify(err), err)
+			return ev
+		} else {
+			pool.SetTLSIntercepted(proxyAddr, false)
+		}
+	}
+
+	// UDP能力只影响是否用于UDP转发，不影响检测结果
+	if probes.udp != nil && strings.HasPrefix(proxyAddr, "socks5://") {
+		ev.Stage = StageUDP
+		ev.UDP = probes.udp.supports(proxyAddr, timeoutDur)
+		pool.SetUDP(proxyAddr, ev.UDP)
+	}
+
+	// 匿名级别同样不影响检测结果，探测失败时不记录
+	if probes.anonymity != nil {
+		ev.Stage = StageAnonymity
+		if level, err := probes.anonymity.level(probes, proxyAddr, timeoutDur); err == nil {
+			ev.Anonymity = level
+			pool.SetAnonymity(proxyAddr, level)
+		}
+	}
+
+	ev.Stage = StagePassed
+	ev.Alive = true
+	pool.SetVerified(proxyAddr, ev.Profile, checkLatency)
+	return ev
+}
+
+var checksTotal = metrics.NewCounterVec("checks_total", "代理检测次数，按来源插件和结果区分", "source", "result")
+
+// sourceLabel 来源为空时记为unknown，与按来源插件的失败统计一致
+func sourceLabel(source string) string {
+	if source == "" {
+		return "unknown"
+	}
+	return source
+}
+
+func checkResult(alive bool) string {
+	if alive {
+		return "passed"
+	}
+	return "failed"
+}
+
+// jsonField 按点分路径（如 data.country）从JSON中取出字段值，取不到时返回空字符串
+func jsonField(body []byte, path string) string {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return ""
+	}
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		if v, ok = m[key]; !ok {
+			return ""
 		}
 	}
-	return true
+	switch val := v.(type) {
+	case string:
+		return val
+	case nil, map[string]interface{}, []interface{}:
+		return ""
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// fail 记录检测失败原因
+func (ev *CheckEvent) fail(class errclass.Class, err error) {
+	ev.Alive = false
+	ev.ErrorClass = class
+	if err != nil {
+		ev.Error = err.Error()
+	}
 }
 
+// 检测worker的停止信号，StopCheckWorkers关闭后worker完成当前检测即退出
+var (
+	workerStop = make(chan struct{})
+	workerWG   sync.WaitGroup
+	stopOnce   sync.Once
+)
+
 func StartCheckWorkers(workerNum int, checkCfg config.CheckSocksConfig, proxyStore pool.ProxyStore) {
 	logger.Info("启动 %d 个代理检测工作线程", workerNum)
+	probes := loadProbes(checkCfg, proxyStore)
 	for i := 0; i < workerNum; i++ {
-		go checkWorker(checkCfg, proxyStore)
+		workerWG.Add(1)
+		go checkWorker(checkCfg, proxyStore, probes)
+	}
+}
+
+// StopCheckWorkers 停止检测worker并等待进行中的检测完成，队列中未检测的代理直接丢弃
+func StopCheckWorkers(ctx context.Context) error {
+	stopOnce.Do(func() {
+		close(workerStop)
+	})
+	done := make(chan struct{})
+	go func() {
+		workerWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.New("等待进行中的检测超时")
 	}
 }
 
 // 检测worker，从ToCheckChan取代理，检测通过才入库
-func checkWorker(checkCfg config.CheckSocksConfig, proxyStore pool.ProxyStore) {
-	for proxy := range globals.ToCheckChan {
-		if checkProxyAlive(proxy, checkCfg.CheckURL, checkCfg.Timeout, checkCfg.CheckRspKeywords, checkCfg.CheckGeolocate.Switch == "open", checkCfg.CheckGeolocate) {
-			proxyStore.Add(proxy)
+func checkWorker(checkCfg config.CheckSocksConfig, proxyStore pool.ProxyStore, probes checkProbes) {
+	defer workerWG.Done()
+	for {
+		var task globals.CheckTask
+		select {
+		case <-workerStop:
+			return
+		case task = <-globals.ToCheckChan:
+		}
+		// 被屏蔽的代理（如需要认证）不再重复检测
+		if pool.IsBanned(task.Proxy) {
+			continue
+		}
+		pool.SetSource(task.Proxy, task.Source)
+		ev := checkProxyAlive(task.Proxy, checkCfg.CheckURL, checkCfg.Timeout, checkCfg.CheckRspKeywords, checkCfg.CheckGeolocate.Switch == "open", checkCfg.CheckGeolocate, probes)
+		if ev.Alive {
+			proxyStore.Add(task.Proxy)
+			pool.HandleSuccess(task.Proxy)
+		} else {
+			// 检测失败自动丢弃，按错误分类记录统计
+			pool.HandleFailure(proxyStore, task.Proxy, ev.ErrorClass)
 		}
-		// 检测失败自动丢弃
 	}
 }
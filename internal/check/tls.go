@@ -0,0 +1,169 @@
+package check
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/overflow0verture/proxy_harvester/internal/config"
+	"github.com/overflow0verture/proxy_harvester/internal/errclass"
+	"github.com/overflow0verture/proxy_harvester/internal/logger"
+	"github.com/overflow0verture/proxy_harvester/internal/netutil"
+	"github.com/overflow0verture/proxy_harvester/internal/pool"
+)
+
+// TLS中间人检测的处置方式
+const (
+	TLSActionFlag   = "flag"   // 仅标记，代理仍可入库
+	TLSActionReject = "reject" // 直接判定检测失败
+)
+
+// errTLSIntercepted 通过代理拿到的证书链与预期不符
+var errTLSIntercepted = errors.New("检测到TLS中间人，代理返回的证书链与预期不符")
+
+// tlsProbe 通过代理对指定主机做一次完整校验的TLS握手，用于发现篡改HTTPS的代理
+type tlsProbe struct {
+	host       string // host:port
+	serverName string
+	rootCAs    *x509.CertPool // 为nil时使用系统根证书
+	pins       [][]byte       // 证书公钥(SPKI)的SHA256，命中证书链中任一证书即可
+	action     string
+}
+
+// newTLSProbe 根据配置创建TLS探测器，未开启时返回nil
+func newTLSProbe(cfg config.CheckTLSConfig) (*tlsProbe, error) {
+	if cfg.Switch != "open" {
+		return nil, nil
+	}
+	if cfg.Host == "" {
+		return nil, fmt.Errorf("checkTLS.host不能为空")
+	}
+
+	host := cfg.Host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "443")
+	}
+	serverName, _, _ := net.SplitHostPort(host)
+
+	probe := &tlsProbe{
+		host:       host,
+		serverName: serverName,
+		action:     strings.ToLower(cfg.Action),
+	}
+	if probe.action != TLSActionReject {
+		probe.action = TLSActionFlag
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取CA文件失败: %v", err)
+		}
+		probe.rootCAs = x509.NewCertPool()
+		if !probe.rootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA文件中没有有效证书: %s", cfg.CAFile)
+		}
+	}
+
+	for _, pin := range cfg.PinSHA256 {
+		sum, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, "sha256/"))
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("无效的证书公钥指纹: %s", pin)
+		}
+		probe.pins = append(probe.pins, sum)
+	}
+
+	return probe, nil
+}
+
+// checkProbes 主检测通过后执行的附加检测，字段为nil表示未开启
+type checkProbes struct {
+	tls *tlsProbe
+	udp *udpProbe
+	// anonymity 匿名度探测
+	anonymity *anonymityProbe
+	// chain 非nil时经代理链检测完整链路，用于选择链中其他代理池跳
+	chain pool.ProxyStore
+}
+
+// dial 通过代理连接目标，开启代理链检测时经过完整的代理链
+func (p checkProbes) dial(ctx context.Context, proxyAddr, network, address string, timeout time.Duration) (net.Conn, error) {
+	if p.chain != nil {
+		return netutil.DialThroughChain(ctx, p.chain, proxyAddr, network, address, timeout)
+	}
+	return netutil.DialViaProxyContext(ctx, proxyAddr, network, address, timeout)
+}
+
+// loadProbes 根据检测配置创建附加检测，配置错误时记录日志并关闭对应检测
+func loadProbes(cfg config.CheckSocksConfig, proxyStore pool.ProxyStore) checkProbes {
+	var probes checkProbes
+	if cfg.CheckThroughChain && len(netutil.Chain) > 0 {
+		probes.chain = proxyStore
+	}
+	tlsProbe, err := newTLSProbe(cfg.CheckTLS)
+	if err != nil {
+		logger.Error("TLS中间人检测配置无效，已关闭: %v", err)
+	} else {
+		probes.tls = tlsProbe
+	}
+	probes.udp = newUDPProbe(cfg.CheckUDP)
+	probes.anonymity = newAnonymityProbe(cfg.CheckAnonymity)
+	return probes
+}
+
+// verify 通过代理完成一次TLS握手并校验证书链
+// 返回errTLSIntercepted表示证书不符，其他错误表示网络或握手失败
+func (p *tlsProbe) verify(probes checkProbes, proxyAddr string, timeout time.Duration) error {
+	conn, err := probes.dial(context.Background(), proxyAddr, "tcp", p.host, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	tlsConf := &tls.Config{
+		ServerName: p.serverName,
+		RootCAs:    p.rootCAs,
+	}
+	if len(p.pins) > 0 {
+		tlsConf.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, cert := range cs.PeerCertificates {
+				sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				for _, pin := range p.pins {
+					if bytes.Equal(sum[:], pin) {
+						return nil
+					}
+				}
+			}
+			return errTLSIntercepted
+		}
+	}
+
+	tlsConn := tls.Client(conn, tlsConf)
+	if err := tlsConn.Handshake(); err != nil {
+		if errors.Is(err, errTLSIntercepted) || isCertificateError(err) {
+			return errclass.Wrap(errclass.TLSFailure, errTLSIntercepted)
+		}
+		return err
+	}
+	return nil
+}
+
+// isCertificateError 判断握手失败是否由证书校验引起
+func isCertificateError(err error) bool {
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	return errors.As(err, &certErr) || errors.As(err, &unknownAuthority) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &invalidErr)
+}
@@ -0,0 +1,96 @@
+package check
+
+import (
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/overflow0verture/proxy_harvester/internal/config"
+	"github.com/overflow0verture/proxy_harvester/internal/netutil"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// udpProbe 通过socks5代理的UDP ASSOCIATE发送一次DNS查询，判断代理是否支持UDP转发
+type udpProbe struct {
+	dnsServer string
+	domain    dnsmessage.Name
+}
+
+// newUDPProbe 根据配置创建UDP探测器，未开启时返回nil
+func newUDPProbe(cfg config.CheckUDPConfig) *udpProbe {
+	if cfg.Switch != "open" {
+		return nil
+	}
+	server := cfg.DNSServer
+	if server == "" {
+		server = "8.8.8.8:53"
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	domain := cfg.Domain
+	if domain == "" {
+		domain = "www.baidu.com"
+	}
+	if domain[len(domain)-1] != '.' {
+		domain += "."
+	}
+	name, err := dnsmessage.NewName(domain)
+	if err != nil {
+		return nil
+	}
+	return &udpProbe{dnsServer: server, domain: name}
+}
+
+// supports 检测代理是否能转发UDP，非socks5代理直接返回false
+func (p *udpProbe) supports(proxyAddr string, timeout time.Duration) bool {
+	assoc, err := netutil.DialUDPViaProxy(proxyAddr, timeout)
+	if err != nil {
+		return false
+	}
+	defer assoc.Close()
+
+	id := uint16(rand.Intn(65536))
+	query, err := p.buildQuery(id)
+	if err != nil {
+		return false
+	}
+	if err := assoc.WriteTo(query, p.dnsServer); err != nil {
+		return false
+	}
+
+	assoc.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 4096)
+	for {
+		n, _, err := assoc.ReadFrom(buf)
+		if err != nil {
+			return false
+		}
+		if p.isAnswer(buf[:n], id) {
+			return true
+		}
+	}
+}
+
+// buildQuery 构造A记录查询报文
+func (p *udpProbe) buildQuery(id uint16) ([]byte, error) {
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  p.domain,
+			Type:  dnsmessage.TypeA,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	return msg.Pack()
+}
+
+// isAnswer 判断收到的报文是否为对应查询的应答
+func (p *udpProbe) isAnswer(pkt []byte, id uint16) bool {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(pkt)
+	if err != nil {
+		return false
+	}
+	return header.ID == id && header.Response
+}
@@ -3,8 +3,11 @@
 package config
 
 import (
-	toml "github.com/pelletier/go-toml/v2"
 	"os"
+	"strconv"
+	"strings"
+
+	toml "github.com/pelletier/go-toml/v2"
 )
 
 // BruteForceConfig 暴力破解配置
@@ -28,8 +31,76 @@ type ListenerConfig struct {
 	Port     int    `toml:"PORT"`
 	UserName string `toml:"userName"`
 	Password string `toml:"password"`
+	UDP      bool   `toml:"udp"` // 是否支持UDP ASSOCIATE（需代理池中有支持UDP的socks5代理）
+	// UsernameRouting 是否解析用户名中的选路参数，如 user-country-CN-session-abc123-proto-socks5-label-fofa
+	UsernameRouting bool `toml:"usernameRouting"`
+	// SessionTTL 粘性会话租约时长（秒），为0时默认600
+	SessionTTL int `toml:"sessionTTL"`
+	// DialAttempts 单次连接最多尝试的上游代理数，为0时默认3
+	DialAttempts int `toml:"dialAttempts"`
+	// DialBudget 单次连接尝试上游代理的总时长（秒），为0时默认15
+	DialBudget int `toml:"dialBudget"`
+	// RaceDials 同时竞速拨号的上游数（2-3），保留最先建立的连接，小于2不竞速
+	RaceDials int `toml:"raceDials"`
 }
 
+// HTTPListenerConfig 本地HTTP正向代理监听配置
+// 支持CONNECT隧道和绝对URI转发，与socks5监听共用代理池轮换
+type HTTPListenerConfig struct {
+	Switch   string `toml:"switch"`
+	IP       string `toml:"IP"`
+	Port     int    `toml:"PORT"`
+	UserName string `toml:"userName"`
+	Password string `toml:"password"`
+	// RaceDials 同时竞速拨号的上游数，小于2不竞速
+	RaceDials int `toml:"raceDials"`
+	// Rotation 轮换方式，request时普通HTTP请求每个请求重新选择上游，见ListenerEntry
+	Rotation string `toml:"rotation"`
+}
+
+// ListenerEntry 单个本地监听，每个监听独立的协议、认证、选择策略、代理筛选条件和轮换方式
+type ListenerEntry struct {
+	Name     string `toml:"name"`     // 监听名称，用于日志和访问统计，为空时使用协议名
+	Protocol string `toml:"protocol"` // socks5/http/transparent
+	IP       string `toml:"IP"`
+	Port     int    `toml:"PORT"`
+	UserName string `toml:"userName"`
+	Password string `toml:"password"`
+	UDP      bool   `toml:"udp"` // 仅socks5，是否支持UDP ASSOCIATE
+	// TProxy 仅transparent，接收iptables TPROXY转来的连接（需CAP_NET_ADMIN），否则按REDIRECT取原始目标地址
+	TProxy bool `toml:"tproxy"`
+	// UsernameRouting 仅socks5，是否解析用户名中的选路参数，用户名中的参数优先于监听的筛选条件
+	UsernameRouting bool `toml:"usernameRouting"`
+	// RaceDials 同时竞速拨号的上游数，小于2不竞速
+	RaceDials int `toml:"raceDials"`
+	// Strategy 选择策略：round_robin（默认）、weighted（按健康分加权随机）、random、sticky（同一客户端IP固定同一个上游）
+	Strategy string `toml:"strategy"`
+	// Rotation 轮换方式：connection（默认，每个连接重新选择）、interval（每RotateInterval秒更换一次上游）、
+	// request（仅http，同一客户端连接上的普通HTTP请求每个请求重新选择上游，CONNECT隧道仍按连接）
+	Rotation string `toml:"rotation"`
+	// RotateInterval interval轮换的间隔（秒），为0时默认60
+	RotateInterval int `toml:"rotateInterval"`
+	// 代理筛选条件，为空表示不限
+	Country string   `toml:"country"` // 归属地国家，需在checkGeolocate中配置countryField
+	Proto   string   `toml:"proto"`   // 上游代理协议：socks5/socks4/socks4a/http/https
+	Labels  []string `toml:"labels"`  // 来源插件名或标签，匹配任一即可
+	Profile string   `toml:"profile"` // 通过的检测方式：default/geolocate
+}
+
+// 监听协议
+const (
+	ProtocolSocks5      = "socks5"
+	ProtocolHTTP        = "http"
+	ProtocolTransparent = "transparent" // 透明代理，仅Linux
+)
+
+// 轮换方式
+const (
+	RotationConnection = "connection"
+	RotationInterval   = "interval"
+	RotationRequest    = "request"
+)
+
 // TaskConfig 定时任务配置
 type TaskConfig struct {
 	PeriodicChecking string `toml:"periodicChecking"`
@@ -41,6 +112,10 @@ type LogConfig struct {
 	LogDir  string `toml:"log_dir"`
 	// 代理IP汇总间隔（分钟）
 	IPSummaryInterval int `toml:"ip_summary_interval"`
+	// 代理检测审计日志（JSON Lines，追加写入），为空则不记录
+	CheckAuditFile string `toml:"check_audit_file"`
+	// 本地监听访问日志（JSON Lines，追加写入），每个隧道连接一条，为空则不记录
+	AccessLogFile string `toml:"access_log_file"`
 }
 
 // CheckGeolocateConfig 地理位置检测配置
@@ -49,6 +124,36 @@ type CheckGeolocateConfig struct {
 	CheckURL        string   `toml:"checkURL"`
 	ExcludeKeywords []string `toml:"excludeKeywords"`
 	IncludeKeywords []string `toml:"includeKeywords"`
+	// CountryField 归属地接口JSON响应中国家/地区字段的路径，如 data.country，为空则不记录
+	CountryField string `toml:"countryField"`
+	// ASNField 归属地接口JSON响应中自治系统号字段的路径，如 data.asnumber，为空则不记录
+	ASNField string `toml:"asnField"`
+}
+
+// CheckTLSConfig TLS中间人检测配置
+// 通过代理对Host做一次完整校验的TLS握手，证书链不符即视为代理篡改HTTPS
+type CheckTLSConfig struct {
+	Switch    string   `toml:"switch"`
+	Host      string   `toml:"host"`      // 目标主机，如 www.baidu.com:443
+	CAFile    string   `toml:"caFile"`    // 可选，PEM格式的根证书，为空使用系统根证书
+	PinSHA256 []string `toml:"pinSHA256"` // 可选，证书公钥(SPKI)SHA256的base64值，命中证书链中任一证书即可
+	Action    string   `toml:"action"`    // flag:仅标记 reject:判定检测失败
+}
+
+// CheckUDPConfig UDP能力探测配置
+// 通过socks5代理的UDP ASSOCIATE向DNSServer查询Domain，收到应答即认为支持UDP
+type CheckUDPConfig struct {
+	Switch    string `toml:"switch"`
+	DNSServer string `toml:"dnsServer"`
+	Domain    string `toml:"domain"`
+}
+
+// CheckAnonymityConfig 匿名度探测配置
+// 通过代理访问JudgeURL（返回请求头的http地址），按响应中是否出现本机IP和代理相关请求头判断匿名级别
+type CheckAnonymityConfig struct {
+	Switch   string `toml:"switch"`
+	JudgeURL string `toml:"judgeURL"` // 如 http://httpbin.org/headers，需为http以便观察代理添加的请求头
+	IPURL    string `toml:"ipURL"`    // 直接访问时返回本机出口IP的地址，如 http://api.ipify.org
 }
 
 // CheckSocksConfig 代理检测配置
@@ -58,6 +163,11 @@ type CheckSocksConfig struct {
 	MaxConcurrentReq int                  `toml:"maxConcurrentReq"`
 	Timeout          int                  `toml:"timeout"`
 	CheckGeolocate   CheckGeolocateConfig `toml:"checkGeolocate"`
+	CheckTLS         CheckTLSConfig       `toml:"checkTLS"`
+	CheckUDP         CheckUDPConfig       `toml:"checkUDP"`
+	CheckAnonymity   CheckAnonymityConfig `toml:"checkAnonymity"`
+	// CheckThroughChain 配置了代理链时经完整代理链检测，只保留能作为链中一跳使用的代理
+	CheckThroughChain bool `toml:"checkThroughChain"`
 }
 
 // PluginConfig 插件相关配置
@@ -75,15 +185,103 @@ type APIServerConfig struct {
 	Port   int    `toml:"port"`
 }
 
+// ACLConfig 本地监听的目标地址访问控制，对SOCKS5和HTTP监听同时生效
+// 规则格式：主机[:端口]，主机可以是域名、通配符域名、IP、CIDR或*，端口可以是范围，如 10.0.0.0/8、*.example.com:443、*:25
+type ACLConfig struct {
+	AllowPrivate bool     `toml:"allowPrivate"` // 是否允许访问内网、链路本地和回环地址，默认拒绝
+	Allow        []string `toml:"allow"`        // 允许规则，为空时除拒绝规则外都放行
+	Deny         []string `toml:"deny"`         // 拒绝规则，优先级最高
+}
+
+// ChainConfig 上游代理链，本地监听的请求依次经过每一跳
+// Hops 每一跳是固定代理地址（socks5/socks4/socks4a/http/https）或 pool（从代理池选择），至少包含一个pool
+type ChainConfig struct {
+	Hops []string `toml:"hops"`
+}
+
+// UpstreamTLSConfig 与https://上游代理的TLS连接配置，对本地监听和检测同时生效
+// 免费代理多为自签名证书，默认不校验；SNI为代理的主机名，可在代理地址中用sni参数指定
+type UpstreamTLSConfig struct {
+	Verify bool   `toml:"verify"` // 是否校验代理证书
+	CAFile string `toml:"caFile"` // 可选，PEM格式的根证书，为空使用系统根证书
+}
+
+// ShutdownConfig 收到SIGINT/SIGTERM后按阶段关闭：停止监听、等待连接结束、停止定时任务、等待检测完成、刷新代理池、关闭日志
+// Timeout 每个阶段最长等待时间（秒），为0时默认30，等待连接结束超时后强制关闭剩余连接
+type ShutdownConfig struct {
+	Timeout int `toml:"timeout"`
+}
+
+// PACConfig PAC文件及客户端配置生成，规则格式：域名、通配符域名（如 *.google.com）或IPv4 CIDR
+type PACConfig struct {
+	Host     string   `toml:"host"`     // 客户端访问本地监听使用的地址，为空时使用监听IP，监听在0.0.0.0时使用API请求的主机名
+	Listener string   `toml:"listener"` // PAC指向的监听名称，为空时使用全部SOCKS5和HTTP监听
+	Proxy    []string `toml:"proxy"`    // 经代理池访问的规则
+	Direct   []string `toml:"direct"`   // 直连的规则，优先级最高
+	Default  string   `toml:"default"`  // 都不匹配时：proxy（默认）经代理池，direct直连
+}
+
+// UsersConfig 多用户配置，开启后本地监听和API按用户认证
+// Store: file 从File指定的JSON文件加载，storage 与代理池共用[storage]中的Redis
+type UsersConfig struct {
+	Switch string `toml:"switch"`
+	Store  string `toml:"store"`
+	File   string `toml:"file"`
+}
+
 // Config 是全局配置结构体
 type Config struct {
-	Listener   ListenerConfig   `toml:"listener"`
-	Task       TaskConfig       `toml:"task"`
-	CheckSocks CheckSocksConfig `toml:"checkSocks"`
-	Storage    StorageConfig    `toml:"storage"`
-	Plugin     PluginConfig     `toml:"plugin"`
-	Log        LogConfig        `toml:"log"`
-	APIServer  APIServerConfig  `toml:"apiserver"`
+	Listener     ListenerConfig     `toml:"listener"`
+	HTTPListener HTTPListenerConfig `toml:"httpListener"`
+	Task         TaskConfig         `toml:"task"`
+	CheckSocks   CheckSocksConfig   `toml:"checkSocks"`
+	Storage      StorageConfig      `toml:"storage"`
+	Plugin       PluginConfig       `toml:"plugin"`
+	Log          LogConfig          `toml:"log"`
+	APIServer    APIServerConfig    `toml:"apiserver"`
+	Users        UsersConfig        `toml:"users"`
+	ACL          ACLConfig          `toml:"acl"`
+	Chain        ChainConfig        `toml:"chain"`
+	UpstreamTLS  UpstreamTLSConfig  `toml:"upstreamTLS"`
+	Shutdown     ShutdownConfig     `toml:"shutdown"`
+	PAC          PACConfig          `toml:"pac"`
+	// Listeners 额外的本地监听，与[listener]、[httpListener]同时生效
+	Listeners []ListenerEntry `toml:"listeners"`
+}
+
+// ListenerEntries 全部本地监听：[listener]、开启时的[httpListener]，以及[[listeners]]中的监听
+func (c Config) ListenerEntries() []ListenerEntry {
+	entries := []ListenerEntry{{
+		Name:            ProtocolSocks5,
+		Protocol:        ProtocolSocks5,
+		IP:              c.Listener.IP,
+		Port:            c.Listener.Port,
+		UserName:        c.Listener.UserName,
+		Password:        c.Listener.Password,
+		UDP:             c.Listener.UDP,
+		UsernameRouting: c.Listener.UsernameRouting,
+		RaceDials:       c.Listener.RaceDials,
+	}}
+	if strings.ToLower(c.HTTPListener.Switch) == "open" {
+		entries = append(entries, ListenerEntry{
+			Name:      ProtocolHTTP,
+			Protocol:  ProtocolHTTP,
+			IP:        c.HTTPListener.IP,
+			Port:      c.HTTPListener.Port,
+			UserName:  c.HTTPListener.UserName,
+			Password:  c.HTTPListener.Password,
+			RaceDials: c.HTTPListener.RaceDials,
+			Rotation:  c.HTTPListener.Rotation,
+		})
+	}
+	for _, entry := range c.Listeners {
+		entry.Protocol = strings.ToLower(entry.Protocol)
+		if entry.Name == "" {
+			entry.Name = entry.Protocol + ":" + strconv.Itoa(entry.Port)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
 }
 
 // LoadConfig 负责加载 TOML 配置文件
@@ -0,0 +1,189 @@
+package errclass
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Class 代理检测/拨号失败的错误分类
+type Class string
+
+const (
+	None              Class = ""
+	DNS               Class = "dns"                // 域名解析失败
+	ConnectRefused    Class = "connect_refused"    // 连接被拒绝
+	ConnectTimeout    Class = "connect_timeout"    // 连接或读写超时
+	HandshakeRejected Class = "handshake_rejected" // 代理协议握手被拒绝
+	AuthRequired      Class = "auth_required"      // 代理要求认证或认证失败
+	ConnectNon200     Class = "connect_non_200"    // HTTP CONNECT 返回非2xx
+	TLSFailure        Class = "tls_failure"        // TLS握手失败
+	KeywordMismatch   Class = "keyword_mismatch"   // 响应中未找到关键字
+	GeoExcluded       Class = "geo_excluded"       // 归属地被排除
+	Unsupported       Class = "unsupported"        // 不支持的代理类型或网络
+	Other             Class = "other"              // 其他错误
+)
+
+// known 全部已定义的分类
+var known = map[Class]bool{
+	DNS: true, ConnectRefused: true, ConnectTimeout: true, HandshakeRejected: true, AuthRequired: true,
+	ConnectNon200: true, TLSFailure: true, KeywordMismatch: true, GeoExcluded: true, Unsupported: true, Other: true,
+}
+
+// Parse 解析外部提交的错误分类，空字符串为None，未定义的分类返回false
+func Parse(s string) (Class, bool) {
+	class := Class(s)
+	return class, class == None || known[class]
+}
+
+// Error 携带分类信息的错误
+type Error struct {
+	Class Class
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// New 创建带分类的错误
+func New(class Class, message string) error {
+	return &Error{Class: class, Err: errors.New(message)}
+}
+
+// Wrap 为已有错误附加分类，err为nil时返回nil
+func Wrap(class Class, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Class: class, Err: err}
+}
+
+// Classify 判断错误所属分类
+func Classify(err error) Class {
+	if err == nil {
+		return None
+	}
+
+	var classified *Error
+	if errors.As(err, &classified) {
+		return classified.Class
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return DNS
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ConnectTimeout
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return ConnectRefused
+	}
+
+	var recordErr tls.RecordHeaderError
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	if errors.As(err, &recordErr) || errors.As(err, &certErr) ||
+		errors.As(err, &unknownAuthority) || errors.As(err, &hostnameErr) {
+		return TLSFailure
+	}
+
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "connection refused"):
+		return ConnectRefused
+	case strings.Contains(msg, "Proxy Authentication Required"),
+		strings.Contains(msg, "authentication failed"),
+		strings.Contains(msg, "invalid username/password"),
+		strings.Contains(msg, "no acceptable authentication methods"):
+		return AuthRequired
+	case strings.Contains(msg, "tls:"):
+		return TLSFailure
+	}
+
+	// golang.org/x/net/proxy 握手阶段的错误统一带有 "socks connect" 操作名
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && strings.HasPrefix(opErr.Op, "socks") {
+		return HandshakeRejected
+	}
+
+	return Other
+}
+
+// Policy 针对某类错误的处置策略
+type Policy struct {
+	Retry      bool          // 是否换下一个代理重试
+	Recheck    bool          // 插件再次提交时是否重新检测
+	Quarantine time.Duration // 大于0时隔离而非删除
+}
+
+// 各分类的处置策略，未列出的分类使用defaultPolicy
+var policies = map[Class]Policy{
+	// 需要认证的代理在凭据变更前不可能通过，不再重试也不再检测
+	AuthRequired: {Retry: false, Recheck: false},
+	// 超时多为临时拥塞，先隔离，等待周期自检恢复
+	ConnectTimeout: {Retry: true, Recheck: true, Quarantine: 10 * time.Minute},
+	// 不支持的类型换代理也没有意义
+	Unsupported: {Retry: false, Recheck: false},
+}
+
+var defaultPolicy = Policy{Retry: true, Recheck: true}
+
+// PolicyFor 获取错误分类对应的处置策略
+func PolicyFor(class Class) Policy {
+	if p, ok := policies[class]; ok {
+		return p
+	}
+	return defaultPolicy
+}
+
+// 按来源插件统计的失败次数
+var (
+	statsMu sync.Mutex
+	stats   = make(map[string]map[Class]int64)
+)
+
+// Record 记录一次来源插件的失败，source为空时记为unknown
+func Record(source string, class Class) {
+	if class == None {
+		return
+	}
+	if source == "" {
+		source = "unknown"
+	}
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	if stats[source] == nil {
+		stats[source] = make(map[Class]int64)
+	}
+	stats[source][class]++
+}
+
+// Snapshot 返回按来源插件分组的失败统计副本
+func Snapshot() map[string]map[Class]int64 {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	result := make(map[string]map[Class]int64, len(stats))
+	for source, counts := range stats {
+		copied := make(map[Class]int64, len(counts))
+		for class, n := range counts {
+			copied[class] = n
+		}
+		result[source] = copied
+	}
+	return result
+}
@@ -0,0 +1,80 @@
+package errclass
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// timeoutErr 超时的net.Error
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want Class
+	}{
+		{"nil", nil, None},
+		{"已分类", New(AuthRequired, "需要认证"), AuthRequired},
+		{"包装后的已分类错误", fmt.Errorf("拨号失败: %w", Wrap(ConnectNon200, errors.New("502"))), ConnectNon200},
+		{"DNS", &net.DNSError{Err: "no such host", Name: "example.invalid"}, DNS},
+		{"超时", &net.OpError{Op: "dial", Net: "tcp", Err: timeoutErr{}}, ConnectTimeout},
+		{"ctx超时", context.DeadlineExceeded, ConnectTimeout},
+		{"ECONNREFUSED", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, ConnectRefused},
+		{"connection refused文本", errors.New("dial tcp 1.2.3.4:1080: connection refused"), ConnectRefused},
+		{"证书不受信任", x509.UnknownAuthorityError{}, TLSFailure},
+		{"tls文本", errors.New("remote error: tls: handshake failure"), TLSFailure},
+		{"407文本", errors.New("407 Proxy Authentication Required"), AuthRequired},
+		{"socks认证失败", errors.New("socks connect tcp 1.2.3.4:1080->example.com:443: username/password authentication failed"), AuthRequired},
+		{"socks握手", &net.OpError{Op: "socks connect", Net: "tcp", Err: errors.New("unknown error general SOCKS server failure")}, HandshakeRejected},
+		{"其他", errors.New("unexpected"), Other},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.err); got != tt.want {
+				t.Errorf("Classify(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyFor(t *testing.T) {
+	tests := []struct {
+		class Class
+		want  Policy
+	}{
+		{AuthRequired, Policy{Retry: false, Recheck: false}},
+		{ConnectTimeout, Policy{Retry: true, Recheck: true, Quarantine: 10 * time.Minute}},
+		{Unsupported, Policy{Retry: false, Recheck: false}},
+		{ConnectRefused, defaultPolicy},
+		{Other, defaultPolicy},
+		{None, defaultPolicy},
+	}
+	for _, tt := range tests {
+		if got := PolicyFor(tt.class); got != tt.want {
+			t.Errorf("PolicyFor(%q) = %+v, want %+v", tt.class, got, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, s := range []string{"", "dns", "connect_timeout", "auth_required"} {
+		if _, ok := Parse(s); !ok {
+			t.Errorf("Parse(%q) 应为已知分类", s)
+		}
+	}
+	if _, ok := Parse("bogus"); ok {
+		t.Error(`Parse("bogus") 应为未知分类`)
+	}
+}
@@ -0,0 +1,78 @@
+package event
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// AuditLog 将事件以JSON Lines格式追加写入审计文件
+type AuditLog struct {
+	file   *os.File
+	writer *bufio.Writer
+	cancel func()
+	done   chan struct{}
+	once   sync.Once
+}
+
+// StartAuditLog 订阅指定类型的事件并追加写入path，写入慢于发布时事件在内存中排队而不丢弃
+func StartAuditLog(path string, types ...string) (*AuditLog, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("创建审计日志目录失败: %v", err)
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("打开审计日志文件失败: %v", err)
+	}
+
+	ch, cancel := SubscribeLossless(types...)
+	a := &AuditLog{
+		file:   file,
+		writer: bufio.NewWriter(file),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go a.run(ch)
+	return a, nil
+}
+
+// run 消费事件并逐行写入，暂无新事件时刷新缓冲
+func (a *AuditLog) run(ch <-chan Event) {
+	defer close(a.done)
+	encoder := json.NewEncoder(a.writer)
+	for {
+		var (
+			ev Event
+			ok bool
+		)
+		select {
+		case ev, ok = <-ch:
+		default:
+			a.writer.Flush()
+			ev, ok = <-ch
+		}
+		if !ok {
+			break
+		}
+		encoder.Encode(ev)
+	}
+	a.writer.Flush()
+}
+
+// Close 停止订阅，写完已排队的事件后关闭审计文件
+func (a *AuditLog) Close() error {
+	var err error
+	a.once.Do(func() {
+		a.cancel()
+		<-a.done
+		err = a.file.Close()
+	})
+	return err
+}
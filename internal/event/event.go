@@ -0,0 +1,273 @@
+package event
+
+import (
+	"sync"
+	"time"
+)
+
+// 事件类型
+const (
+	TypeCheck      = "check"      // 代理检测完成
+	TypeSession    = "session"    // 粘性会话租约变更
+	TypeAccess     = "access"     // 本地监听的访问记录
+	TypeFeedback   = "feedback"   // 客户端反馈的代理使用结果
+	TypePool       = "pool"       // 代理入库、剔除或隔离
+	TypePlugin     = "plugin"     // 插件开始或结束一次收集
+	TypeConnection = "connection" // 本地监听的连接打开或关闭
+)
+
+// Types 全部事件类型
+var Types = []string{TypeCheck, TypeSession, TypeAccess, TypeFeedback, TypePool, TypePlugin, TypeConnection}
+
+// historySize 事件总线保留的最近事件数，供订阅者从指定序号恢复
+const historySize = 1024
+
+// Event 事件总线中传递的通用事件
+// Seq: 全局递增序号，Type: 事件类型，Data: 具体事件内容
+type Event struct {
+	Seq  uint64      `json:"seq"`
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// subscriber 单个订阅者
+type subscriber struct {
+	ch    chan Event
+	types map[string]bool // 为空表示订阅全部类型
+	queue *queue          // 非nil时为不丢事件的订阅者，事件先进入无界队列
+}
+
+// Bus 进程内事件总线，发布不阻塞，普通订阅者消费过慢时事件会被丢弃
+type Bus struct {
+	mu      sync.Mutex
+	seq     uint64
+	nextID  int
+	subs    map[int]*subscriber
+	history []Event // 最近的historySize个事件
+}
+
+// NewBus 创建事件总线
+func NewBus() *Bus {
+	return &Bus{
+		subs: make(map[int]*subscriber),
+	}
+}
+
+// Publish 发布事件
+func (b *Bus) Publish(typ string, data interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	ev := Event{
+		Seq:  b.seq,
+		Type: typ,
+		Time: time.Now(),
+		Data: data,
+	}
+	b.history = append(b.history, ev)
+	if len(b.history) > historySize {
+		b.history = b.history[len(b.history)-historySize:]
+	}
+
+	for _, sub := range b.subs {
+		if len(sub.types) > 0 && !sub.types[typ] {
+			continue
+		}
+		if sub.queue != nil {
+			sub.queue.push(ev)
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			// 订阅者通道已满，丢弃该事件
+		}
+	}
+}
+
+// Subscribe 订阅事件，types为空时订阅全部类型
+// 返回事件通道和取消订阅函数
+func (b *Bus) Subscribe(buffer int, types ...string) (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.subscribe(buffer, types)
+}
+
+// SubscribeLossless 订阅事件且不丢弃，types为空时订阅全部类型
+// 事件先进入无界队列再依次送入通道，发布方不会被阻塞；取消订阅后队列中剩余的事件仍会送出，之后通道关闭
+func (b *Bus) SubscribeLossless(types ...string) (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &subscriber{
+		ch:    make(chan Event),
+		types: make(map[string]bool),
+		queue: newQueue(),
+	}
+	for _, t := range types {
+		sub.types[t] = true
+	}
+	id := b.nextID
+	b.nextID++
+	b.subs[id] = sub
+	go sub.queue.pump(sub.ch)
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, id)
+			b.mu.Unlock()
+			sub.queue.close()
+		})
+	}
+	return sub.ch, cancel
+}
+
+// queue 不丢事件订阅者的无界队列
+type queue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	items  []Event
+	closed bool
+}
+
+func newQueue() *queue {
+	q := &queue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push 追加事件
+func (q *queue) push(ev Event) {
+	q.mu.Lock()
+	q.items = append(q.items, ev)
+	q.mu.Unlock()
+	q.cond.Signal()
+}
+
+// close 停止接收事件，pump送完剩余事件后关闭通道
+func (q *queue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.cond.Signal()
+}
+
+// pump 依次将队列中的事件送入ch
+func (q *queue) pump(ch chan<- Event) {
+	defer close(ch)
+	for {
+		q.mu.Lock()
+		for len(q.items) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if len(q.items) == 0 {
+			q.mu.Unlock()
+			return
+		}
+		ev := q.items[0]
+		q.items[0] = Event{}
+		q.items = q.items[1:]
+		q.mu.Unlock()
+		ch <- ev
+	}
+}
+
+// SubscribeSince 订阅事件，同时返回保留的事件中序号大于since且类型匹配的事件
+// 返回的事件与通道中的事件不重复也不遗漏；since之后的事件已有部分不再保留时missed为true
+func (b *Bus) SubscribeSince(since uint64, buffer int, types ...string) (backlog []Event, missed bool, ch <-chan Event, cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// since大于当前序号说明序号来自重启之前，从保留的最早事件开始
+	if since > b.seq {
+		since = 0
+		missed = true
+	}
+	if len(b.history) > 0 && b.history[0].Seq > since+1 {
+		missed = true
+	}
+	for _, ev := range b.history {
+		if ev.Seq > since && matchType(types, ev.Type) {
+			backlog = append(backlog, ev)
+		}
+	}
+	ch, cancel = b.subscribe(buffer, types)
+	return backlog, missed, ch, cancel
+}
+
+// LastSeq 最近发布的事件序号
+func (b *Bus) LastSeq() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.seq
+}
+
+func matchType(types []string, typ string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, t := range types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// subscribe 注册订阅者，调用方需持有mu
+func (b *Bus) subscribe(buffer int, types []string) (<-chan Event, func()) {
+	sub := &subscriber{
+		ch:    make(chan Event, buffer),
+		types: make(map[string]bool),
+	}
+	for _, t := range types {
+		sub.types[t] = true
+	}
+
+	id := b.nextID
+	b.nextID++
+	b.subs[id] = sub
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, id)
+			b.mu.Unlock()
+			close(sub.ch)
+		})
+	}
+	return sub.ch, cancel
+}
+
+// 全局事件总线
+var defaultBus = NewBus()
+
+// Publish 向全局事件总线发布事件
+func Publish(typ string, data interface{}) {
+	defaultBus.Publish(typ, data)
+}
+
+// Subscribe 订阅全局事件总线
+func Subscribe(buffer int, types ...string) (<-chan Event, func()) {
+	return defaultBus.Subscribe(buffer, types...)
+}
+
+// SubscribeLossless 订阅全局事件总线且不丢弃事件
+func SubscribeLossless(types ...string) (<-chan Event, func()) {
+	return defaultBus.SubscribeLossless(types...)
+}
+
+// SubscribeSince 订阅全局事件总线，并返回序号大于since的保留事件
+func SubscribeSince(since uint64, buffer int, types ...string) ([]Event, bool, <-chan Event, func()) {
+	return defaultBus.SubscribeSince(since, buffer, types...)
+}
+
+// LastSeq 全局事件总线最近发布的事件序号
+func LastSeq() uint64 {
+	return defaultBus.LastSeq()
+}
@@ -0,0 +1,145 @@
+package event
+
+import (
+	"testing"
+	"time"
+)
+
+// seqs 事件序号列表
+func seqs(events []Event) []uint64 {
+	result := make([]uint64, len(events))
+	for i, ev := range events {
+		result[i] = ev.Seq
+	}
+	return result
+}
+
+// receive 从通道读取n个事件
+func receive(t *testing.T, ch <-chan Event, n int) []Event {
+	t.Helper()
+	events := make([]Event, 0, n)
+	for len(events) < n {
+		select {
+		case ev := <-ch:
+			events = append(events, ev)
+		case <-time.After(time.Second):
+			t.Fatalf("只收到 %d 个事件, want %d", len(events), n)
+		}
+	}
+	return events
+}
+
+func equalSeqs(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSubscribeSinceResume(t *testing.T) {
+	b := NewBus()
+	for i := 0; i < 5; i++ {
+		b.Publish(TypeCheck, i)
+		b.Publish(TypePool, i)
+	}
+
+	backlog, missed, ch, cancel := b.SubscribeSince(4, 16, TypePool)
+	defer cancel()
+	if missed {
+		t.Error("历史完整时不应报告缺失")
+	}
+	// 序号大于4的pool事件：6、8、10
+	if got := seqs(backlog); !equalSeqs(got, []uint64{6, 8, 10}) {
+		t.Errorf("补发的事件 = %v, want [6 8 10]", got)
+	}
+
+	// 订阅后发布的事件从通道送达，与补发的事件不重复
+	b.Publish(TypeCheck, "x")
+	b.Publish(TypePool, "y")
+	if got := seqs(receive(t, ch, 1)); !equalSeqs(got, []uint64{12}) {
+		t.Errorf("通道中的事件 = %v, want [12]", got)
+	}
+	if b.LastSeq() != 12 {
+		t.Errorf("LastSeq = %d, want 12", b.LastSeq())
+	}
+}
+
+func TestSubscribeSinceLatest(t *testing.T) {
+	b := NewBus()
+	b.Publish(TypeCheck, 1)
+	backlog, missed, _, cancel := b.SubscribeSince(b.LastSeq(), 1)
+	defer cancel()
+	if len(backlog) != 0 || missed {
+		t.Errorf("从最新序号订阅 = %v, missed=%v", seqs(backlog), missed)
+	}
+}
+
+func TestSubscribeSinceGap(t *testing.T) {
+	b := NewBus()
+	for i := 0; i < historySize+10; i++ {
+		b.Publish(TypeCheck, i)
+	}
+
+	// 序号11及之前的事件已不再保留
+	backlog, missed, _, cancel := b.SubscribeSince(5, 1)
+	cancel()
+	if !missed {
+		t.Error("历史已被覆盖时应报告缺失")
+	}
+	if len(backlog) != historySize || backlog[0].Seq != 11 {
+		t.Errorf("补发 %d 个事件，首个序号 %d, want %d 个从11开始", len(backlog), backlog[0].Seq, historySize)
+	}
+
+	// 保留范围的起点之前一个序号恰好不缺失
+	_, missed, _, cancel = b.SubscribeSince(10, 1)
+	cancel()
+	if missed {
+		t.Error("since+1为最早保留的事件时不应报告缺失")
+	}
+
+	// 序号大于当前序号（如服务重启）时从保留的最早事件开始并报告缺失
+	backlog, missed, _, cancel = b.SubscribeSince(b.LastSeq()+100, 1)
+	cancel()
+	if !missed || len(backlog) != historySize {
+		t.Errorf("未来序号: missed=%v, 补发 %d 个", missed, len(backlog))
+	}
+}
+
+func TestSubscribeDropsWhenFull(t *testing.T) {
+	b := NewBus()
+	ch, cancel := b.Subscribe(2)
+	defer cancel()
+	for i := 0; i < 5; i++ {
+		b.Publish(TypeCheck, i)
+	}
+	if len(ch) != 2 {
+		t.Errorf("通道中有 %d 个事件, want 2", len(ch))
+	}
+}
+
+func TestSubscribeLossless(t *testing.T) {
+	b := NewBus()
+	ch, cancel := b.SubscribeLossless(TypeAccess)
+	const n = 5000
+	for i := 0; i < n; i++ {
+		b.Publish(TypeAccess, i)
+		b.Publish(TypeCheck, i)
+	}
+	// 取消订阅后仍送出已排队的事件，之后关闭通道
+	cancel()
+	count := 0
+	for ev := range ch {
+		if ev.Type != TypeAccess || ev.Data.(int) != count {
+			t.Fatalf("第%d个事件 = %+v", count, ev)
+		}
+		count++
+	}
+	if count != n {
+		t.Errorf("收到 %d 个事件, want %d", count, n)
+	}
+}
@@ -9,9 +9,15 @@ var (
 	//Wg            sync.WaitGroup
 	//Mu            sync.Mutex
 	//Semaphore     chan struct{}
-	ToCheckChan chan string
+	ToCheckChan chan CheckTask
 )
 
+// CheckTask 待检测的代理及其来源插件
+type CheckTask struct {
+	Proxy  string
+	Source string
+}
+
 // 获取当前代理索引
 //func GetCurrentProxyIndex() int {
 //	Mu.Lock()
@@ -30,5 +36,5 @@ var (
 
 // InitFetchChannel 初始化待检测通道
 func InitFetchChannel(size int) {
-	ToCheckChan = make(chan string, size)
+	ToCheckChan = make(chan CheckTask, size)
 }
@@ -0,0 +1,130 @@
+package lifecycle
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/overflow0verture/proxy_harvester/internal/logger"
+)
+
+// Phase 关闭阶段，按定义顺序依次执行
+type Phase int
+
+const (
+	PhaseListeners Phase = iota // 停止接受新连接
+	PhaseDrain                  // 等待活动连接结束，超时后强制关闭
+	PhaseCron                   // 停止插件和定时任务
+	PhaseChecks                 // 等待进行中的检测完成
+	PhaseStore                  // 刷新代理池
+	PhaseLogs                   // 关闭审计日志等
+	phaseCount
+)
+
+var phaseNames = [phaseCount]string{"停止监听", "等待连接结束", "停止定时任务", "等待检测完成", "刷新代理池", "关闭日志"}
+
+// DefaultTimeout 每个阶段默认的最长等待时间
+const DefaultTimeout = 30 * time.Second
+
+// hook 关闭时执行的函数，ctx到期时应尽快返回
+type hook struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+var (
+	mu      sync.Mutex
+	hooks   [phaseCount][]hook
+	stopped bool
+)
+
+// OnShutdown 注册关闭时执行的函数，同一阶段的函数并发执行
+func OnShutdown(phase Phase, name string, stop func(ctx context.Context) error) {
+	mu.Lock()
+	defer mu.Unlock()
+	hooks[phase] = append(hooks[phase], hook{name: name, stop: stop})
+}
+
+// OnShutdownFunc 注册不需要等待的关闭函数
+func OnShutdownFunc(phase Phase, name string, stop func() error) {
+	OnShutdown(phase, name, func(context.Context) error {
+		return stop()
+	})
+}
+
+// Wait 阻塞直到收到SIGINT或SIGTERM，然后按阶段关闭，timeout为每个阶段的最长等待时间
+// 关闭过程中再次收到信号时立即退出
+func Wait(timeout time.Duration) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	logger.Info("收到信号 %v，开始关闭", sig)
+
+	go func() {
+		sig := <-signals
+		logger.Error("关闭过程中再次收到信号 %v，立即退出", sig)
+		os.Exit(1)
+	}()
+	Shutdown(timeout)
+}
+
+// Shutdown 按阶段执行已注册的关闭函数，只执行一次
+func Shutdown(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	mu.Lock()
+	if stopped {
+		mu.Unlock()
+		return
+	}
+	stopped = true
+	all := hooks
+	mu.Unlock()
+
+	start := time.Now()
+	for phase, phaseHooks := range all {
+		if len(phaseHooks) == 0 {
+			continue
+		}
+		runPhase(Phase(phase), phaseHooks, timeout)
+	}
+	logger.Info("关闭完成，用时 %v", time.Since(start).Round(time.Millisecond))
+}
+
+// runPhase 并发执行同一阶段的关闭函数，等待全部返回或超时
+func runPhase(phase Phase, phaseHooks []hook, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	logger.Info("关闭阶段: %s", phaseNames[phase])
+	var wg sync.WaitGroup
+	for _, h := range phaseHooks {
+		wg.Add(1)
+		go func(h hook) {
+			defer wg.Done()
+			if err := h.stop(ctx); err != nil {
+				logger.Error("关闭 %s 失败: %v", h.name, err)
+			}
+		}(h)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		// 关闭函数应在ctx到期时返回，这里再留出一点时间后放弃等待
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			logger.Error("关闭阶段 %s 超时", phaseNames[phase])
+		}
+	}
+}
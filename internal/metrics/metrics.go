@@ -0,0 +1,282 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Namespace 所有指标名称的前缀
+const Namespace = "proxy_harvester_"
+
+// 指标类型
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// DefaultBuckets 耗时类直方图的默认分桶（秒）
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// collector 单个指标，抓取时输出为Prometheus文本格式
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]collector)
+)
+
+// register 注册指标，同名指标以后注册的为准
+func register(name string, c collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = c
+}
+
+// WriteText 以Prometheus文本格式输出全部指标，按名称排序
+func WriteText(out io.Writer) error {
+	registryMu.Lock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	collectors := make([]collector, 0, len(names))
+	for _, name := range names {
+		collectors = append(collectors, registry[name])
+	}
+	registryMu.Unlock()
+
+	w := bufio.NewWriter(out)
+	for _, c := range collectors {
+		c.write(w)
+	}
+	return w.Flush()
+}
+
+// desc 指标的名称、说明、类型和标签名
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d desc) writeHeader(w *bufio.Writer) {
+	w.WriteString("# HELP " + d.name + " " + d.help + "\n")
+	w.WriteString("# TYPE " + d.name + " " + d.typ + "\n")
+}
+
+// writeSample 输出一行样本，extra为附加的标签（如直方图的le）
+func (d desc) writeSample(w *bufio.Writer, suffix string, values []string, extraName, extraValue string, v float64) {
+	w.WriteString(d.name + suffix)
+	if len(d.labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, label := range d.labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(label + `="` + escapeLabel(values[i]) + `"`)
+		}
+		if extraName != "" {
+			if len(d.labels) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extraName + `="` + extraValue + `"`)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteString(" " + formatFloat(v) + "\n")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// labelKey 标签值的组合键，标签值数量不足时补空
+func labelKey(n int, values []string) (string, []string) {
+	if len(values) != n {
+		fixed := make([]string, n)
+		copy(fixed, values)
+		values = fixed
+	}
+	return strings.Join(values, "\xff"), values
+}
+
+// CounterVec 按标签区分的计数器
+type CounterVec struct {
+	desc
+	mu     sync.Mutex
+	values map[string][]string // 组合键 -> 标签值
+	counts map[string]float64
+}
+
+// NewCounterVec 创建并注册计数器，name不含前缀
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		desc:   desc{name: Namespace + name, help: help, typ: typeCounter, labels: labels},
+		values: make(map[string][]string),
+		counts: make(map[string]float64),
+	}
+	register(c.name, c)
+	return c
+}
+
+// Add 计数增加v，标签值按创建时的标签名顺序传入
+func (c *CounterVec) Add(v float64, values ...string) {
+	key, values := labelKey(len(c.labels), values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.values[key]; !ok {
+		c.values[key] = values
+	}
+	c.counts[key] += v
+}
+
+// Inc 计数加1
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w)
+	for _, key := range sortedKeys(c.values) {
+		c.writeSample(w, "", c.values[key], "", "", c.counts[key])
+	}
+}
+
+// sortedKeys 排序后的组合键，使输出稳定
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// histogramData 单组标签的直方图数据，counts为各分桶（不含+Inf）的非累计计数
+type histogramData struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// HistogramVec 按标签区分的直方图
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string][]string
+	data    map[string]*histogramData
+}
+
+// NewHistogramVec 创建并注册直方图，buckets为升序的分桶上界，为空时使用DefaultBuckets
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	h := &HistogramVec{
+		desc:    desc{name: Namespace + name, help: help, typ: typeHistogram, labels: labels},
+		buckets: buckets,
+		values:  make(map[string][]string),
+		data:    make(map[string]*histogramData),
+	}
+	register(h.name, h)
+	return h
+}
+
+// Observe 记录一个观测值
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	key, values := labelKey(len(h.labels), values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	d, ok := h.data[key]
+	if !ok {
+		d = &histogramData{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = values
+		h.data[key] = d
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		d.counts[i]++
+	}
+	d.count++
+	d.sum += v
+}
+
+// ObserveDuration 以秒为单位记录耗时
+func (h *HistogramVec) ObserveDuration(d time.Duration, values ...string) {
+	h.Observe(d.Seconds(), values...)
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w)
+	for _, key := range sortedKeys(h.values) {
+		values, d := h.values[key], h.data[key]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += d.counts[i]
+			h.writeSample(w, "_bucket", values, "le", formatFloat(upper), float64(cumulative))
+		}
+		h.writeSample(w, "_bucket", values, "le", "+Inf", float64(d.count))
+		h.writeSample(w, "_sum", values, "", "", d.sum)
+		h.writeSample(w, "_count", values, "", "", float64(d.count))
+	}
+}
+
+// Sample 抓取时计算的一个样本，Values按注册时的标签名顺序
+type Sample struct {
+	Values []string
+	Value  float64
+}
+
+// funcCollector 抓取时调用collect计算样本的指标
+type funcCollector struct {
+	desc
+	collect func() []Sample
+}
+
+func (f *funcCollector) write(w *bufio.Writer) {
+	samples := f.collect()
+	f.writeHeader(w)
+	for _, s := range samples {
+		_, values := labelKey(len(f.labels), s.Values)
+		f.writeSample(w, "", values, "", "", s.Value)
+	}
+}
+
+// RegisterGaugeFunc 注册抓取时计算的仪表盘指标，同名指标重复注册时替换
+func RegisterGaugeFunc(name, help string, labels []string, collect func() []Sample) {
+	f := &funcCollector{desc: desc{name: Namespace + name, help: help, typ: typeGauge, labels: labels}, collect: collect}
+	register(f.name, f)
+}
+
+// RegisterCounterFunc 注册抓取时读取的计数器指标，用于已有统计中单调递增的计数
+func RegisterCounterFunc(name, help string, labels []string, collect func() []Sample) {
+	f := &funcCollector{desc: desc{name: Namespace + name, help: help, typ: typeCounter, labels: labels}, collect: collect}
+	register(f.name, f)
+}
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+// resetRegistry 清空全局注册表，避免受其他包注册的指标影响
+func resetRegistry(t *testing.T) {
+	t.Helper()
+	registryMu.Lock()
+	saved := registry
+	registry = make(map[string]collector)
+	registryMu.Unlock()
+	t.Cleanup(func() {
+		registryMu.Lock()
+		registry = saved
+		registryMu.Unlock()
+	})
+}
+
+func TestWriteText(t *testing.T) {
+	resetRegistry(t)
+
+	checks := NewCounterVec("checks_total", "代理检测次数", "source", "result")
+	checks.Inc("fofa", "alive")
+	checks.Inc("fofa", "alive")
+	checks.Add(3, "hunter", "dead")
+	checks.Inc(`a"b\c`, "dead")
+
+	latency := NewHistogramVec("dial_seconds", "拨号耗时", []float64{0.1, 1}, "result")
+	latency.Observe(0.05, "ok")
+	latency.Observe(0.5, "ok")
+	latency.Observe(2, "ok")
+
+	RegisterGaugeFunc("pool_size", "代理池规模", nil, func() []Sample {
+		return []Sample{{Value: 42}}
+	})
+
+	var buf bytes.Buffer
+	if err := WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP proxy_harvester_checks_total 代理检测次数
+# TYPE proxy_harvester_checks_total counter
+proxy_harvester_checks_total{source="a\"b\\c",result="dead"} 1
+proxy_harvester_checks_total{source="fofa",result="alive"} 2
+proxy_harvester_checks_total{source="hunter",result="dead"} 3
+# HELP proxy_harvester_dial_seconds 拨号耗时
+# TYPE proxy_harvester_dial_seconds histogram
+proxy_harvester_dial_seconds_bucket{result="ok",le="0.1"} 1
+proxy_harvester_dial_seconds_bucket{result="ok",le="1"} 2
+proxy_harvester_dial_seconds_bucket{result="ok",le="+Inf"} 3
+proxy_harvester_dial_seconds_sum{result="ok"} 2.55
+proxy_harvester_dial_seconds_count{result="ok"} 3
+# HELP proxy_harvester_pool_size 代理池规模
+# TYPE proxy_harvester_pool_size gauge
+proxy_harvester_pool_size 42
+`
+	if got := buf.String(); got != want {
+		t.Errorf("WriteText 输出:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogramBucketBoundary(t *testing.T) {
+	resetRegistry(t)
+	h := NewHistogramVec("boundary_seconds", "边界", []float64{1, 2})
+	h.Observe(1)
+	h.Observe(2)
+
+	var buf bytes.Buffer
+	WriteText(&buf)
+	// 等于上界的观测值计入该分桶
+	for _, line := range []string{
+		`proxy_harvester_boundary_seconds_bucket{le="1"} 1`,
+		`proxy_harvester_boundary_seconds_bucket{le="2"} 2`,
+		`proxy_harvester_boundary_seconds_bucket{le="+Inf"} 2`,
+	} {
+		if !bytes.Contains(buf.Bytes(), []byte(line+"\n")) {
+			t.Errorf("缺少 %s:\n%s", line, buf.String())
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package netutil
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/overflow0verture/proxy_harvester/internal/errclass"
+	"github.com/overflow0verture/proxy_harvester/internal/pool"
+)
+
+// ChainPoolHop 代理链中表示从代理池选择代理的占位
+const ChainPoolHop = "pool"
+
+// Chain 上游代理链，按顺序经过每一跳，为空时直接使用代理池中的代理
+// 每一跳是固定代理地址（socks5/socks4/socks4a/http/https）或ChainPoolHop，如：
+// [socks5://10.0.0.1:1080 pool]、[pool pool]、[pool http://exit.example.com:3128]
+var Chain []string
+
+// SetChain 校验并设置代理链，链中至少包含一个代理池跳
+func SetChain(hops []string) error {
+	if len(hops) == 0 {
+		Chain = nil
+		return nil
+	}
+	hasPool := false
+	for _, hop := range hops {
+		if hop == ChainPoolHop {
+			hasPool = true
+			continue
+		}
+		u, err := url.Parse(hop)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("代理链地址格式错误: %s", hop)
+		}
+		switch u.Scheme {
+		case "socks5", "socks4", "socks4a", "http", "https":
+		default:
+			return fmt.Errorf("代理链不支持的协议: %s", hop)
+		}
+	}
+	if !hasPool {
+		return errors.New("代理链中至少需要一个pool跳")
+	}
+	Chain = append([]string(nil), hops...)
+	return nil
+}
+
+// HopError 代理链中某一跳失败
+type HopError struct {
+	Hop   int    // 失败的跳，从0开始
+	Proxy string // 该跳的代理地址
+	Err   error
+}
+
+func (e *HopError) Error() string {
+	return fmt.Sprintf("代理链第%d跳 %s 失败: %v", e.Hop+1, e.Proxy, e.Err)
+}
+
+func (e *HopError) Unwrap() error {
+	return e.Err
+}
+
+// DestinationError 某一跳的代理协议无法连接该目标，如socks4代理不支持IPv6目标和非tcp网络
+// 这不是代理本身的故障，不计入健康分，换下一个代理重试
+type DestinationError struct {
+	Err error
+}
+
+func (e *DestinationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DestinationError) Unwrap() error {
+	return e.Err
+}
+
+// destinationErr 创建分类为Unsupported的DestinationError
+func destinationErr(message string) error {
+	return &DestinationError{Err: errclass.New(errclass.Unsupported, message)}
+}
+
+// isDestinationError 错误是否因代理协议不支持目标而产生
+func isDestinationError(err error) bool {
+	var destErr *DestinationError
+	return errors.As(err, &destErr)
+}
+
+// DialThroughChain 按Chain经proxyAddr连接目标，proxyAddr填入第一个代理池跳，其余代理池跳从代理池另选
+// 未配置代理链时等同于DialViaProxyContext
+func DialThroughChain(ctx context.Context, proxyStore pool.ProxyStore, proxyAddr, network, address string, timeout time.Duration) (net.Conn, error) {
+	if len(Chain) == 0 {
+		return DialViaProxyContext(ctx, proxyAddr, network, address, timeout)
+	}
+
+	hops := make([]string, len(Chain))
+	used := map[string]bool{proxyAddr: true}
+	filled := false
+	for i, hop := range Chain {
+		if hop != ChainPoolHop {
+			hops[i] = hop
+			continue
+		}
+		if !filled {
+			hops[i] = proxyAddr
+			filled = true
+			continue
+		}
+		other, err := pool.Select(proxyStore, pool.Filter{})
+		if err != nil {
+			return nil, ErrNoProxy
+		}
+		// 尽量避免同一个代理在链中出现两次
+		if used[other] {
+			if again, err := pool.Select(proxyStore, pool.Filter{}); err == nil {
+				other = again
+			}
+		}
+		used[other] = true
+		hops[i] = other
+	}
+	return DialHops(ctx, hops, network, address, timeout)
+}
+
+// IsChainHop 代理是否为代理链中配置的固定跳
+func IsChainHop(proxyAddr string) bool {
+	for _, hop := range Chain {
+		if hop == proxyAddr {
+			return true
+		}
+	}
+	return false
+}
+
+// DialHops 依次经过hops中的代理连接目标，timeout为整个链路的建立时长
+// 多跳时返回的错误为*HopError，指明失败的跳
+func DialHops(ctx context.Context, hops []string, network, address string, timeout time.Duration) (net.Conn, error) {
+	if len(hops) == 0 {
+		return nil, errclass.New(errclass.Other, "代理链为空")
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	hopErr := func(i int, err error) error {
+		if len(hops) == 1 {
+			return err
+		}
+		return &HopError{Hop: i, Proxy: hops[i], Err: err}
+	}
+
+	urls := make([]*url.URL, len(hops))
+	for i, hop := range hops {
+		u, err := url.Parse(hop)
+		if err != nil {
+			return nil, hopErr(i, errclass.Wrap(errclass.Other, err))
+		}
+		urls[i] = u
+	}
+	if !isKnownScheme(urls[0].Scheme) {
+		return nil, hopErr(0, errclass.New(errclass.Unsupported, "未知代理类型"))
+	}
+
+	conn, err := dialHops(ctx, urls, network, address, timeout, hopErr, true)
+	if errors.Is(err, errAuthRetry) {
+		// http代理返回407质询后关闭了连接，重新建立链路携带凭据再试一次
+		conn, err = dialHops(ctx, urls, network, address, timeout, hopErr, false)
+	}
+	return conn, err
+}
+
+// dialHops 建立一次到第一跳的连接并依次完成各跳握手，retryAuth见httpConnect
+func dialHops(ctx context.Context, urls []*url.URL, network, address string, timeout time.Duration, hopErr func(int, error) error, retryAuth bool) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	raw, err := dialer.DialContext(ctx, "tcp", urls[0].Host)
+	if err != nil {
+		return nil, hopErr(0, err)
+	}
+	// 握手期间ctx取消时关闭连接，使阻塞的读写立即返回
+	stop := context.AfterFunc(ctx, func() {
+		raw.Close()
+	})
+	defer stop()
+
+	deadline, _ := ctx.Deadline()
+	conn := raw
+	for i, u := range urls {
+		nextNetwork, next := network, address
+		if i+1 < len(urls) {
+			nextNetwork, next = "tcp", urls[i+1].Host
+		}
+		raw.SetDeadline(deadline)
+		conn, err = handshake(ctx, conn, u, nextNetwork, next, retryAuth)
+		if err != nil {
+			raw.Close()
+			return nil, hopErr(i, handshakeErr(ctx, err))
+		}
+	}
+
+	if !stop() {
+		// ctx已取消，连接已被关闭
+		return nil, hopErr(len(urls)-1, handshakeErr(ctx, net.ErrClosed))
+	}
+	raw.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func isKnownScheme(scheme string) bool {
+	switch scheme {
+	case "socks5", "socks4", "socks4a", "http", "https":
+		return true
+	}
+	return false
+}
+
+// handshake 在到代理u的连接上请求连接下一跳或目标
+func handshake(ctx context.Context, conn net.Conn, u *url.URL, network, address string, retryAuth bool) (net.Conn, error) {
+	switch u.Scheme {
+	case "socks5":
+		return socks5Connect(ctx, conn, u, network, address)
+	case "socks4", "socks4a":
+		return socks4Connect(conn, u, network, address)
+	case "http":
+		return httpConnect(conn, u, network, address, retryAuth)
+	case "https":
+		tlsConn, err := tlsConnect(ctx, conn, u)
+		if err != nil {
+			return nil, err
+		}
+		return httpConnect(tlsConn, u, network, address, retryAuth)
+	}
+	return nil, errclass.New(errclass.Unsupported, "未知代理类型")
+}
+
+// socks4Connect 在到socks4代理的连接上发送CONNECT请求，域名目标使用SOCKS4a扩展
+func socks4Connect(conn net.Conn, u *url.URL, network, address string) (net.Conn, error) {
+	if network != "tcp" {
+		return nil, destinationErr("socks4代理仅支持tcp网络")
+	}
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, errclass.Wrap(errclass.Other, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, errclass.Wrap(errclass.Other, err)
+	}
+
+	ip := net.ParseIP(host)
+	if ip != nil && ip.To4() == nil {
+		return nil, destinationErr("socks4代理不支持IPv6目标")
+	}
+
+	req := []byte{0x04, 0x01, 0, 0}
+	binary.BigEndian.PutUint16(req[2:], uint16(port))
+	if ip != nil {
+		req = append(req, ip.To4()...)
+	} else {
+		// SOCKS4a：IP为0.0.0.x，域名附在用户名之后
+		req = append(req, 0, 0, 0, 1)
+	}
+	if u.User != nil {
+		req = append(req, u.User.Username()...)
+	}
+	req = append(req, 0)
+	if ip == nil {
+		req = append(req, host...)
+		req = append(req, 0)
+	}
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	reply := make([]byte, 8)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, errclass.Wrap(errclass.HandshakeRejected, err)
+	}
+	if reply[1] != 0x5a {
+		return nil, errclass.New(errclass.HandshakeRejected, fmt.Sprintf("socks4代理拒绝连接，应答码: %d", reply[1]))
+	}
+	return conn, nil
+}
+
+// hopOwner 失败归属的代理：多跳时为失败的那一跳，否则为proxyAddr
+func hopOwner(err error, proxyAddr string) string {
+	var hopErr *HopError
+	if errors.As(err, &hopErr) {
+		return hopErr.Proxy
+	}
+	return proxyAddr
+}
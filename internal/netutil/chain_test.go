@@ -0,0 +1,84 @@
+package netutil
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/overflow0verture/proxy_harvester/internal/errclass"
+	"github.com/overflow0verture/proxy_harvester/internal/logger"
+	"github.com/overflow0verture/proxy_harvester/internal/pool"
+)
+
+func TestMain(m *testing.M) {
+	logger.Setup(false, "")
+	m.Run()
+}
+
+// startSink 启动只接受连接的监听，返回监听地址
+func startSink(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestDestinationErrorNotReported(t *testing.T) {
+	store := pool.NewFileProxyStore(filepath.Join(t.TempDir(), "proxies.txt"), 0)
+	proxies := []string{"socks4://" + startSink(t), "socks4a://" + startSink(t)}
+	for _, p := range proxies {
+		if err := store.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		network string
+		address string
+	}{
+		{"socks4不支持IPv6目标", "tcp", "[2001:db8::1]:80"},
+		{"非tcp网络", "udp", "127.0.0.1:53"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := TransmitReqWithContext(context.Background(), tt.network, tt.address, store, 2)
+			if err == nil {
+				t.Fatal("应返回错误")
+			}
+			if !isDestinationError(err) {
+				t.Errorf("错误 %v 不是DestinationError", err)
+			}
+			if class := errclass.Classify(err); class != errclass.Unsupported {
+				t.Errorf("分类 = %s, want %s", class, errclass.Unsupported)
+			}
+		})
+	}
+
+	// 协议不支持目标时不影响代理的健康分，也不会被剔除
+	all, _ := store.GetAll()
+	if len(all) != len(proxies) {
+		t.Errorf("代理池剩余 %v, want %v", all, proxies)
+	}
+	for _, p := range proxies {
+		if pool.IsQuarantined(p) {
+			t.Errorf("%s 被隔离", p)
+		}
+		if meta, ok := pool.GetMeta(p); ok && (meta.Failures != 0 || meta.LastError != errclass.None) {
+			t.Errorf("%s 记录了失败: %+v", p, meta)
+		}
+	}
+}
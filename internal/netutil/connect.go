@@ -0,0 +1,180 @@
+package netutil
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/overflow0verture/proxy_harvester/internal/errclass"
+)
+
+// maxChallengeBody 在同一连接上重试认证时最多丢弃的407响应体长度
+const maxChallengeBody = 64 << 10
+
+// errAuthRetry 代理返回407质询后关闭了连接，需重新建立连接后携带凭据重试
+var errAuthRetry = errclass.New(errclass.AuthRequired, "代理返回407质询后关闭了连接")
+
+// httpConnect 在已建立的到http代理的连接上发送CONNECT请求
+// 接受任意2xx应答；代理地址带有凭据时首个请求即携带Basic认证头。retryAuth为true时，
+// 收到接受Basic认证的407质询后在同一连接上携带凭据重试一次，有些代理只在质询之后才接受凭据；
+// 无法在同一连接上重试时返回errAuthRetry。应答头之后已读入缓冲的数据会交给隧道
+func httpConnect(conn net.Conn, proxyURL *url.URL, network, address string, retryAuth bool) (net.Conn, error) {
+	if network != "tcp" {
+		return nil, destinationErr("http/https代理仅支持tcp网络")
+	}
+	target := address
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		target = net.JoinHostPort(target, "80")
+	}
+
+	auth := proxyAuthorization(proxyURL)
+	br := bufio.NewReader(conn)
+	resp, err := connectRequest(conn, br, target, auth)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusProxyAuthRequired && retryAuth && auth != "" && basicChallenge(resp) {
+		if !discardChallenge(resp) {
+			return nil, errAuthRetry
+		}
+		resp, err = connectRequest(conn, br, target, auth)
+		if err != nil {
+			// 代理在质询后关闭了连接
+			return nil, errAuthRetry
+		}
+	}
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return withBuffered(conn, br), nil
+	case resp.StatusCode == http.StatusProxyAuthRequired:
+		return nil, errclass.New(errclass.AuthRequired, "CONNECT需要认证: "+resp.Status)
+	}
+	return nil, errclass.New(errclass.ConnectNon200, "CONNECT失败: "+resp.Status)
+}
+
+// connectRequest 发送CONNECT请求并读取应答头，auth非空时携带认证头，成功应答的响应体不读取
+func connectRequest(conn net.Conn, br *bufio.Reader, target, auth string) (*http.Response, error) {
+	req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", target, target)
+	if auth != "" {
+		req += "Proxy-Authorization: " + auth + "\r\n"
+	}
+	req += "\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		return nil, err
+	}
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, errclass.New(errclass.HandshakeRejected, "代理未返回CONNECT应答即关闭了连接")
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) {
+			return nil, err
+		}
+		return nil, errclass.Wrap(errclass.HandshakeRejected, fmt.Errorf("CONNECT应答格式错误: %w", err))
+	}
+	return resp, nil
+}
+
+// proxyAuthorization 代理地址中凭据对应的Basic认证头，没有凭据时返回空
+func proxyAuthorization(proxyURL *url.URL) string {
+	if proxyURL.User == nil {
+		return ""
+	}
+	pass, _ := proxyURL.User.Password()
+	auth := proxyURL.User.Username() + ":" + pass
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(auth))
+}
+
+// basicChallenge 407应答是否接受Basic认证，未给出质询时也按Basic处理
+func basicChallenge(resp *http.Response) bool {
+	challenges := resp.Header.Values("Proxy-Authenticate")
+	if len(challenges) == 0 {
+		return true
+	}
+	for _, header := range challenges {
+		for _, scheme := range challengeSchemes(header) {
+			if strings.EqualFold(scheme, "basic") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// challengeSchemes 解析Proxy-Authenticate头中的认证方案
+// 一个头可以包含多个质询，如 Negotiate, Basic realm="a, b"，引号内的逗号和方案的参数不计入
+func challengeSchemes(header string) []string {
+	var (
+		schemes []string
+		part    strings.Builder
+		quoted  bool
+		escaped bool
+	)
+	flush := func() {
+		token, _, _ := strings.Cut(strings.TrimSpace(part.String()), " ")
+		// 含有'='的是上一个方案的参数
+		if token != "" && !strings.Contains(token, "=") {
+			schemes = append(schemes, token)
+		}
+		part.Reset()
+	}
+	for _, r := range header {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			flush()
+			continue
+		}
+		part.WriteRune(r)
+	}
+	flush()
+	return schemes
+}
+
+// discardChallenge 丢弃407质询的响应体，代理要求关闭连接或响应体过长时返回false
+func discardChallenge(resp *http.Response) bool {
+	defer resp.Body.Close()
+	if resp.Close {
+		return false
+	}
+	n, err := io.Copy(io.Discard, io.LimitReader(resp.Body, maxChallengeBody+1))
+	return err == nil && n <= maxChallengeBody
+}
+
+// bufferedConn 先读出CONNECT应答后已缓冲的数据，再读底层连接
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// withBuffered 缓冲中还有数据时包装连接，避免隧道丢失应答头之后的数据
+func withBuffered(conn net.Conn, br *bufio.Reader) net.Conn {
+	if br.Buffered() == 0 {
+		return conn
+	}
+	return &bufferedConn{Conn: conn, r: br}
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// CloseWrite 关闭写端，底层连接不支持时直接关闭
+func (c *bufferedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
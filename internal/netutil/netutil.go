@@ -1,96 +1,204 @@
 package netutil
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/url"
-	"strings"
 	"time"
 
-	"encoding/base64"
-	"github.com/overflow0verture/proxy_harvester/internal/pool"
+	"github.com/overflow0verture/proxy_harvester/internal/errclass"
 	"github.com/overflow0verture/proxy_harvester/internal/logger"
+	"github.com/overflow0verture/proxy_harvester/internal/pool"
 	"golang.org/x/net/proxy"
 )
 
+// ErrNoProxy 代理池中没有满足条件的代理
+var ErrNoProxy = errors.New("无可用代理")
+
+// 单次客户端连接尝试上游代理的预算，由配置设置
+var (
+	// DialAttempts 最多尝试的上游代理数
+	DialAttempts = 3
+	// DialBudget 尝试上游代理的总时长
+	DialBudget = 15 * time.Second
+)
+
+// BudgetError 重试预算用尽，Err为最后一次拨号的错误
+type BudgetError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("已尝试 %d 个代理均失败: %v", e.Attempts, e.Err)
+}
+
+func (e *BudgetError) Unwrap() error {
+	return e.Err
+}
+
 // 支持socks5/http/https认证代理的转发
 func TransmitReqFromClient(network string, address string, proxyStore pool.ProxyStore, timeout int) (net.Conn, error) {
-	proxyAddr, err := proxyStore.GetNext()
-	if err != nil {
-		return nil, fmt.Errorf("无可用代理")
-	}
-	// fmt.Println(time.Now().Format("2006-01-02 15:04:05") + "\t" + proxyAddr)
+	return TransmitReqWithContext(context.Background(), network, address, proxyStore, timeout)
+}
+
+// TransmitReqWithContext 按ctx中的路由参数选择上游代理并转发，未携带路由时按轮询选择
+// 失败时在DialAttempts和DialBudget范围内换下一个代理重试，失败只计入健康分，不直接剔除代理
+// 路由开启竞速时每轮同时拨号多个上游，计为一次尝试
+func TransmitReqWithContext(ctx context.Context, network string, address string, proxyStore pool.ProxyStore, timeout int) (net.Conn, error) {
+	route := RouteFromContext(ctx)
 	timeoutDur := time.Duration(timeout) * time.Second
+	deadline := time.Now().Add(DialBudget)
 
-	if strings.HasPrefix(proxyAddr, "socks5://") {
-		u, err := url.Parse(proxyAddr)
-		if err != nil {
-			proxyStore.MarkInvalid(proxyAddr)
-			return TransmitReqFromClient(network, address, proxyStore, timeout)
-		}
-		host := u.Host
-		var auth *proxy.Auth
-		if u.User != nil {
-			user := u.User.Username()
-			pass, _ := u.User.Password()
-			auth = &proxy.Auth{User: user, Password: pass}
-		}
-		dialer := &net.Dialer{Timeout: timeoutDur}
-		socksDialer, err := proxy.SOCKS5(network, host, auth, dialer)
-		if err != nil {
-			proxyStore.MarkInvalid(proxyAddr)
-			return TransmitReqFromClient(network, address, proxyStore, timeout)
+	var lastErr error
+	attempts := 0
+	for attempts < DialAttempts {
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
-		conn, err := socksDialer.Dial(network, address)
-		if err != nil {
-			proxyStore.MarkInvalid(proxyAddr)
-			logger.Info("%s无效，自动切换下一个......\n", proxyAddr)
-			return TransmitReqFromClient(network, address, proxyStore, timeout)
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
 		}
-		return conn, nil
-	} else if strings.HasPrefix(proxyAddr, "http://") || strings.HasPrefix(proxyAddr, "https://") {
-		proxyURL, err := url.Parse(proxyAddr)
-		if err != nil {
-			proxyStore.MarkInvalid(proxyAddr)
-			return TransmitReqFromClient(network, address, proxyStore, timeout)
+		dialTimeout := timeoutDur
+		if remaining < dialTimeout {
+			dialTimeout = remaining
 		}
-		dialer := &net.Dialer{Timeout: timeoutDur}
-		if network != "tcp" {
-			return nil, fmt.Errorf("http/https代理仅支持tcp网络")
+
+		var (
+			conn      net.Conn
+			proxyAddr string
+			err       error
+		)
+		// 粘性会话固定使用同一个上游，不参与竞速
+		if route.Race > 1 && route.Session == "" {
+			conn, proxyAddr, err = raceDial(ctx, proxyStore, route.Filter, route.Strategy, route.Race, network, address, dialTimeout)
+		} else {
+			conn, proxyAddr, err = dialOnce(ctx, proxyStore, route, network, address, dialTimeout)
 		}
-		conn, err := dialer.Dial("tcp", proxyURL.Host)
-		if err != nil {
-			proxyStore.MarkInvalid(proxyAddr)
-			logger.Info("%s无效，自动切换下一个......\n", proxyAddr)
-			return TransmitReqFromClient(network, address, proxyStore, timeout)
+		if errors.Is(err, ErrNoProxy) {
+			if lastErr == nil {
+				return nil, err
+			}
+			break
 		}
-		target := address
-		if !strings.Contains(target, ":") {
-			target += ":80"
+		attempts++
+		if err == nil {
+			if trace := dialTraceFromContext(ctx); trace != nil {
+				trace.Proxy = proxyAddr
+				trace.Attempts = attempts
+			}
+			return conn, nil
 		}
-		req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", target, target)
-		if proxyURL.User != nil {
-			user := proxyURL.User.Username()
-			pass, _ := proxyURL.User.Password()
-			auth := user + ":" + pass
-			b64 := "Basic " + base64.StdEncoding.EncodeToString([]byte(auth))
-			req += "Proxy-Authorization: " + b64 + "\r\n"
+		lastErr = err
+		if isDestinationError(err) {
+			// 上游协议不支持该目标，换下一个代理；粘性会话固定的上游无法更换
+			if route.Session != "" {
+				return nil, err
+			}
+			continue
 		}
-		req += "\r\n"
-		_, err = conn.Write([]byte(req))
-		if err != nil {
-			conn.Close()
-			proxyStore.MarkInvalid(proxyAddr)
-			return TransmitReqFromClient(network, address, proxyStore, timeout)
+		if !errclass.PolicyFor(errclass.Classify(err)).Retry {
+			return nil, err
 		}
-		buf := make([]byte, 4096)
-		n, err := conn.Read(buf)
-		if err != nil || !strings.Contains(string(buf[:n]), "200 Connection established") {
-			conn.Close()
-			proxyStore.MarkInvalid(proxyAddr)
-			return TransmitReqFromClient(network, address, proxyStore, timeout)
+	}
+
+	if lastErr == nil {
+		lastErr = errclass.New(errclass.ConnectTimeout, "重试总时长已用尽")
+	}
+	return nil, &BudgetError{Attempts: attempts, Err: lastErr}
+}
+
+// dialOnce 选择一个上游代理拨号，并记录结果到健康分，返回使用的上游代理
+func dialOnce(ctx context.Context, proxyStore pool.ProxyStore, route Route, network, address string, timeout time.Duration) (net.Conn, string, error) {
+	proxyAddr, err := pool.SelectStickyBy(proxyStore, route.Filter, route.Strategy, route.Session)
+	if err != nil {
+		return nil, "", ErrNoProxy
+	}
+
+	start := time.Now()
+	conn, err := DialThroughChain(ctx, proxyStore, proxyAddr, network, address, timeout)
+	if err == nil {
+		pool.ReportDialSuccess(proxyAddr, time.Since(start))
+		return conn, proxyAddr, nil
+	}
+	if ctx.Err() != nil {
+		return nil, proxyAddr, err
+	}
+
+	class := errclass.Classify(err)
+	if !reportHopFailure(proxyStore, err, proxyAddr, class) {
+		return nil, proxyAddr, err
+	}
+	if route.Session != "" {
+		pool.MarkSessionFailed(route.Session, proxyAddr)
+	}
+	logger.Info("%s无效(%s)，自动切换下一个......\n", proxyAddr, class)
+	return nil, proxyAddr, err
+}
+
+// reportHopFailure 将拨号失败计入出错的代理，返回是否计入了proxyAddr
+// 代理链的固定跳出错和代理协议不支持目标时不计入代理池
+func reportHopFailure(proxyStore pool.ProxyStore, err error, proxyAddr string, class errclass.Class) bool {
+	if isDestinationError(err) {
+		return false
+	}
+	owner := hopOwner(err, proxyAddr)
+	if IsChainHop(owner) {
+		logger.Error("代理链固定跳 %s 失败: %v", owner, err)
+		return false
+	}
+	pool.ReportDialFailure(proxyStore, owner, class)
+	return owner == proxyAddr
+}
+
+// DialViaProxy 通过指定的上游代理连接目标地址，返回的错误带有errclass分类
+func DialViaProxy(proxyAddr, network, address string, timeout time.Duration) (net.Conn, error) {
+	return DialViaProxyContext(context.Background(), proxyAddr, network, address, timeout)
+}
+
+// DialViaProxyContext 同DialViaProxy，ctx取消时中止连接和握手
+func DialViaProxyContext(ctx context.Context, proxyAddr, network, address string, timeout time.Duration) (net.Conn, error) {
+	return DialHops(ctx, []string{proxyAddr}, network, address, timeout)
+}
+
+// socks5Connect 在已建立的到socks5代理的连接上完成握手并连接目标
+func socks5Connect(ctx context.Context, conn net.Conn, u *url.URL, network, address string) (net.Conn, error) {
+	var auth *proxy.Auth
+	if u.User != nil {
+		user := u.User.Username()
+		pass, _ := u.User.Password()
+		auth = &proxy.Auth{User: user, Password: pass}
+	}
+	socksDialer, err := proxy.SOCKS5(network, u.Host, auth, connDialer{conn})
+	if err != nil {
+		return nil, errclass.Wrap(errclass.Other, err)
+	}
+	return socksDialer.(proxy.ContextDialer).DialContext(ctx, network, address)
+}
+
+// connDialer 直接返回已建立连接的Dialer，用于在现有连接上进行socks5握手
+type connDialer struct {
+	conn net.Conn
+}
+
+func (d connDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.conn, nil
+}
+
+func (d connDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d.conn, nil
+}
+
+// handshakeErr 握手因ctx结束而失败时返回ctx的错误
+func handshakeErr(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		if errors.Is(ctxErr, context.DeadlineExceeded) {
+			return errclass.Wrap(errclass.ConnectTimeout, ctxErr)
 		}
-		return conn, nil
+		return ctxErr
 	}
-	return nil, fmt.Errorf("未知代理类型")
+	return err
 }
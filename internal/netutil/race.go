@@ -0,0 +1,107 @@
+package netutil
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/overflow0verture/proxy_harvester/internal/errclass"
+	"github.com/overflow0verture/proxy_harvester/internal/logger"
+	"github.com/overflow0verture/proxy_harvester/internal/pool"
+)
+
+// raceResult 单个上游的拨号结果
+type raceResult struct {
+	proxy   string
+	conn    net.Conn
+	err     error
+	latency time.Duration
+}
+
+// raceDial 同时通过n个不同的上游代理拨号，保留最先完成握手的连接并关闭其余连接，返回胜出的上游代理
+// 落败的拨号在后台继续到完成，记录耗时后关闭，以便竞速结果同样计入健康分；
+// 客户端连接结束(ctx取消)时未完成的拨号随之取消，不计入健康分
+func raceDial(ctx context.Context, proxyStore pool.ProxyStore, filter pool.Filter, strategy string, n int, network, address string, timeout time.Duration) (net.Conn, string, error) {
+	proxies := selectDistinct(proxyStore, filter, strategy, n)
+	if len(proxies) == 0 {
+		return nil, "", ErrNoProxy
+	}
+
+	results := make(chan raceResult, len(proxies))
+	for _, proxyAddr := range proxies {
+		go func(proxyAddr string) {
+			start := time.Now()
+			conn, err := DialThroughChain(ctx, proxyStore, proxyAddr, network, address, timeout)
+			results <- raceResult{proxy: proxyAddr, conn: conn, err: err, latency: time.Since(start)}
+		}(proxyAddr)
+	}
+
+	var (
+		winner      net.Conn
+		winnerProxy string
+		lastErr     error
+	)
+	pending := len(proxies)
+	for pending > 0 && winner == nil {
+		res := <-results
+		pending--
+		recordRace(ctx, proxyStore, res)
+		if res.err == nil {
+			winner, winnerProxy = res.conn, res.proxy
+			logger.Debug("竞速拨号 %s 胜出，耗时 %v", res.proxy, res.latency)
+		} else {
+			lastErr = res.err
+		}
+	}
+
+	// 剩余的拨号在后台收尾：记录耗时并关闭落败的连接
+	if pending > 0 {
+		go func() {
+			for ; pending > 0; pending-- {
+				res := <-results
+				recordRace(ctx, proxyStore, res)
+				if res.conn != nil {
+					res.conn.Close()
+				}
+			}
+		}()
+	}
+
+	if winner != nil {
+		return winner, winnerProxy, nil
+	}
+	return nil, "", lastErr
+}
+
+// recordRace 记录竞速中单个上游的结果，因ctx取消而失败的拨号不记录
+func recordRace(ctx context.Context, proxyStore pool.ProxyStore, res raceResult) {
+	if res.err == nil {
+		pool.ReportDialSuccess(res.proxy, res.latency)
+		return
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	class := errclass.Classify(res.err)
+	if reportHopFailure(proxyStore, res.err, res.proxy, class) {
+		logger.Info("%s无效(%s)，竞速拨号失败", res.proxy, class)
+	}
+}
+
+// selectDistinct 按筛选条件和选择策略选出最多n个不同的代理
+func selectDistinct(proxyStore pool.ProxyStore, filter pool.Filter, strategy string, n int) []string {
+	proxies := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	// 轮询或随机可能重复返回同一个代理，多取几次
+	for i := 0; i < n*2 && len(proxies) < n; i++ {
+		proxyAddr, err := pool.SelectBy(proxyStore, filter, strategy)
+		if err != nil {
+			break
+		}
+		if !seen[proxyAddr] {
+			seen[proxyAddr] = true
+			proxies = append(proxies, proxyAddr)
+		}
+	}
+	return proxies
+}
@@ -0,0 +1,46 @@
+package netutil
+
+import (
+	"context"
+
+	"github.com/overflow0verture/proxy_harvester/internal/pool"
+)
+
+// Route 单个客户端连接的选路参数
+type Route struct {
+	Filter   pool.Filter // 上游代理筛选条件
+	Strategy string      // 选择策略，为空表示轮询
+	Session  string      // 粘性会话ID，为空表示每次按策略选择
+	Race     int         // 同时竞速拨号的上游数，小于2表示不竞速
+}
+
+type routeKey struct{}
+
+// WithRoute 在ctx中附加选路参数
+func WithRoute(ctx context.Context, route Route) context.Context {
+	return context.WithValue(ctx, routeKey{}, route)
+}
+
+// RouteFromContext 取出ctx中的选路参数，未设置时返回零值
+func RouteFromContext(ctx context.Context) Route {
+	route, _ := ctx.Value(routeKey{}).(Route)
+	return route
+}
+
+// DialTrace 记录一次转发实际使用的上游，由TransmitReqWithContext在连接成功时填写
+type DialTrace struct {
+	Proxy    string // 建立连接的上游代理
+	Attempts int    // 尝试的轮数
+}
+
+type dialTraceKey struct{}
+
+// WithDialTrace 在ctx中附加拨号记录
+func WithDialTrace(ctx context.Context, trace *DialTrace) context.Context {
+	return context.WithValue(ctx, dialTraceKey{}, trace)
+}
+
+func dialTraceFromContext(ctx context.Context) *DialTrace {
+	trace, _ := ctx.Value(dialTraceKey{}).(*DialTrace)
+	return trace
+}
@@ -0,0 +1,162 @@
+package netutil
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// SOCKS5 协议常量
+const (
+	Socks5Version = 0x05
+
+	Socks5MethodNoAuth       = 0x00
+	Socks5MethodUserPass     = 0x02
+	Socks5MethodNoAcceptable = 0xff
+
+	Socks5CmdConnect      = 0x01
+	Socks5CmdBind         = 0x02
+	Socks5CmdUDPAssociate = 0x03
+
+	Socks5AddrIPv4   = 0x01
+	Socks5AddrDomain = 0x03
+	Socks5AddrIPv6   = 0x04
+)
+
+// SOCKS5 应答码
+const (
+	Socks5RepSuccess              = 0x00
+	Socks5RepServerFailure        = 0x01
+	Socks5RepNotAllowed           = 0x02
+	Socks5RepNetworkUnreachable   = 0x03
+	Socks5RepHostUnreachable      = 0x04
+	Socks5RepConnectionRefused    = 0x05
+	Socks5RepTTLExpired           = 0x06
+	Socks5RepCommandNotSupported  = 0x07
+	Socks5RepAddrTypeNotSupported = 0x08
+)
+
+// AppendSocksAddr 将host:port按SOCKS5地址格式(ATYP+ADDR+PORT)追加到b
+func AppendSocksAddr(b []byte, address string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("无效端口: %s", portStr)
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(b, Socks5AddrIPv4)
+			b = append(b, ip4...)
+		} else {
+			b = append(b, Socks5AddrIPv6)
+			b = append(b, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, errors.New("域名过长")
+		}
+		b = append(b, Socks5AddrDomain, byte(len(host)))
+		b = append(b, host...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port)), nil
+}
+
+// ReadSocksAddr 从流中读取SOCKS5地址(ATYP+ADDR+PORT)，返回host:port
+func ReadSocksAddr(r io.Reader) (string, error) {
+	atyp := make([]byte, 1)
+	if _, err := io.ReadFull(r, atyp); err != nil {
+		return "", err
+	}
+
+	var host string
+	switch atyp[0] {
+	case Socks5AddrIPv4, Socks5AddrIPv6:
+		size := net.IPv4len
+		if atyp[0] == Socks5AddrIPv6 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case Socks5AddrDomain:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(r, l); err != nil {
+			return "", err
+		}
+		domain := make([]byte, l[0])
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		return "", errUnsupportedAddrType
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// errUnsupportedAddrType 不支持的SOCKS5地址类型
+var errUnsupportedAddrType = errors.New("不支持的SOCKS5地址类型")
+
+// IsUnsupportedAddrType 判断错误是否为不支持的地址类型
+func IsUnsupportedAddrType(err error) bool {
+	return errors.Is(err, errUnsupportedAddrType)
+}
+
+// socksAddrReader 读取字节切片的io.Reader，记录已读取长度
+type socksAddrReader struct {
+	b []byte
+	n int
+}
+
+func (r *socksAddrReader) Read(p []byte) (int, error) {
+	if r.n >= len(r.b) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.b[r.n:])
+	r.n += n
+	return n, nil
+}
+
+// PackUDPDatagram 按SOCKS5 UDP请求头格式封装数据报
+// +----+------+------+----------+----------+----------+
+// |RSV | FRAG | ATYP | DST.ADDR | DST.PORT |   DATA   |
+// +----+------+------+----------+----------+----------+
+func PackUDPDatagram(address string, data []byte) ([]byte, error) {
+	b := make([]byte, 0, 3+1+255+2+len(data))
+	b = append(b, 0, 0, 0)
+	b, err := AppendSocksAddr(b, address)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, data...), nil
+}
+
+// UnpackUDPDatagram 解析SOCKS5 UDP数据报，返回目标地址和数据，不支持分片
+func UnpackUDPDatagram(pkt []byte) (string, []byte, error) {
+	if len(pkt) < 4 {
+		return "", nil, errors.New("UDP数据报过短")
+	}
+	if pkt[2] != 0 {
+		return "", nil, errors.New("不支持UDP分片")
+	}
+	r := &socksAddrReader{b: pkt[3:]}
+	address, err := ReadSocksAddr(r)
+	if err != nil {
+		return "", nil, err
+	}
+	return address, pkt[3+r.n:], nil
+}
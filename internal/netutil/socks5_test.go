@@ -0,0 +1,85 @@
+package netutil
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSocksAddrRoundTrip(t *testing.T) {
+	tests := []struct {
+		address string
+		atyp    byte
+	}{
+		{"1.2.3.4:80", Socks5AddrIPv4},
+		{"[2001:db8::1]:443", Socks5AddrIPv6},
+		{"example.com:8080", Socks5AddrDomain},
+		{"example.com:0", Socks5AddrDomain},
+		{"0.0.0.0:65535", Socks5AddrIPv4},
+	}
+	for _, tt := range tests {
+		b, err := AppendSocksAddr(nil, tt.address)
+		if err != nil {
+			t.Fatalf("AppendSocksAddr(%q): %v", tt.address, err)
+		}
+		if b[0] != tt.atyp {
+			t.Errorf("AppendSocksAddr(%q) ATYP = %d, want %d", tt.address, b[0], tt.atyp)
+		}
+		got, err := ReadSocksAddr(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("ReadSocksAddr(%q): %v", tt.address, err)
+		}
+		if got != tt.address {
+			t.Errorf("往返后地址 = %q, want %q", got, tt.address)
+		}
+	}
+}
+
+func TestAppendSocksAddrInvalid(t *testing.T) {
+	for _, address := range []string{"example.com", "example.com:http", "1.2.3.4:70000", string(bytes.Repeat([]byte("a"), 256)) + ":80"} {
+		if _, err := AppendSocksAddr(nil, address); err == nil {
+			t.Errorf("AppendSocksAddr(%q) 应返回错误", address)
+		}
+	}
+}
+
+func TestReadSocksAddrErrors(t *testing.T) {
+	if _, err := ReadSocksAddr(bytes.NewReader([]byte{0x02, 1, 2, 3, 4, 0, 80})); !IsUnsupportedAddrType(err) {
+		t.Errorf("未知ATYP应返回不支持的地址类型，实际为 %v", err)
+	}
+	if _, err := ReadSocksAddr(bytes.NewReader([]byte{Socks5AddrIPv4, 1, 2})); err == nil {
+		t.Error("截断的地址应返回错误")
+	}
+}
+
+func TestUDPDatagramRoundTrip(t *testing.T) {
+	for _, address := range []string{"8.8.8.8:53", "[::1]:5353", "dns.example:53"} {
+		data := []byte("payload for " + address)
+		pkt, err := PackUDPDatagram(address, data)
+		if err != nil {
+			t.Fatalf("PackUDPDatagram(%q): %v", address, err)
+		}
+		gotAddr, gotData, err := UnpackUDPDatagram(pkt)
+		if err != nil {
+			t.Fatalf("UnpackUDPDatagram(%q): %v", address, err)
+		}
+		if gotAddr != address || !bytes.Equal(gotData, data) {
+			t.Errorf("往返后 = (%q, %q), want (%q, %q)", gotAddr, gotData, address, data)
+		}
+	}
+}
+
+func TestUnpackUDPDatagramErrors(t *testing.T) {
+	pkt, _ := PackUDPDatagram("1.2.3.4:53", []byte("x"))
+	fragmented := append([]byte(nil), pkt...)
+	fragmented[2] = 1
+	tests := map[string][]byte{
+		"过短":   {0, 0, 0},
+		"分片":   fragmented,
+		"地址截断": {0, 0, 0, Socks5AddrIPv4, 1, 2},
+	}
+	for name, pkt := range tests {
+		if _, _, err := UnpackUDPDatagram(pkt); err == nil {
+			t.Errorf("%s: UnpackUDPDatagram 应返回错误", name)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package netutil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+
+	"github.com/overflow0verture/proxy_harvester/internal/errclass"
+)
+
+// 与https://上游代理建立TLS连接的配置，由配置设置
+var (
+	proxyTLSMu     sync.RWMutex
+	proxyTLSVerify bool
+	proxyTLSRoots  *x509.CertPool // 为nil时使用系统根证书
+)
+
+// SetProxyTLS 设置https://上游代理的证书校验
+// verify为false时不校验代理证书（免费代理多为自签名证书），caFile为PEM格式的根证书，为空使用系统根证书
+func SetProxyTLS(verify bool, caFile string) error {
+	var roots *x509.CertPool
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return fmt.Errorf("读取CA文件失败: %v", err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("CA文件中没有有效证书: %s", caFile)
+		}
+	}
+	proxyTLSMu.Lock()
+	defer proxyTLSMu.Unlock()
+	proxyTLSVerify = verify
+	proxyTLSRoots = roots
+	return nil
+}
+
+// proxyTLSConfig 连接代理u使用的TLS配置
+// SNI默认为代理的主机名（IP地址不发送SNI），可通过地址参数sni指定，如 https://1.2.3.4:443?sni=proxy.example.com
+func proxyTLSConfig(u *url.URL) *tls.Config {
+	proxyTLSMu.RLock()
+	defer proxyTLSMu.RUnlock()
+	serverName := u.Query().Get("sni")
+	if serverName == "" {
+		serverName = u.Hostname()
+	}
+	return &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: !proxyTLSVerify,
+		RootCAs:            proxyTLSRoots,
+	}
+}
+
+// tlsConnect 在到代理u的连接上完成TLS握手，之后的CONNECT请求在TLS内发送
+func tlsConnect(ctx context.Context, conn net.Conn, u *url.URL) (net.Conn, error) {
+	tlsConn := tls.Client(conn, proxyTLSConfig(u))
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, errclass.Wrap(errclass.TLSFailure, fmt.Errorf("与代理的TLS握手失败: %w", err))
+	}
+	return tlsConn, nil
+}
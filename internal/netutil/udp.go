@@ -0,0 +1,273 @@
+package netutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/overflow0verture/proxy_harvester/internal/errclass"
+	"github.com/overflow0verture/proxy_harvester/internal/logger"
+	"github.com/overflow0verture/proxy_harvester/internal/pool"
+)
+
+// UDPAssociation 与上游SOCKS5代理建立的UDP关联
+// 控制连接(TCP)断开后关联随之失效
+type UDPAssociation struct {
+	Proxy   string
+	ctrl    net.Conn
+	conn    *net.UDPConn
+	relay   *net.UDPAddr
+	once    sync.Once
+	closeCh chan struct{}
+}
+
+// DialUDPViaProxy 向socks5上游代理发起UDP ASSOCIATE
+func DialUDPViaProxy(proxyAddr string, timeout time.Duration) (*UDPAssociation, error) {
+	return DialUDPViaProxyContext(context.Background(), proxyAddr, timeout)
+}
+
+// DialUDPViaProxyContext 同DialUDPViaProxy，ctx取消时中止连接和握手
+func DialUDPViaProxyContext(ctx context.Context, proxyAddr string, timeout time.Duration) (*UDPAssociation, error) {
+	if !strings.HasPrefix(proxyAddr, "socks5://") {
+		return nil, errclass.New(errclass.Unsupported, "仅socks5代理支持UDP")
+	}
+	u, err := url.Parse(proxyAddr)
+	if err != nil {
+		return nil, errclass.Wrap(errclass.Other, err)
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	ctrl, err := dialer.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	// 握手期间ctx取消时关闭连接，使阻塞的读写立即返回
+	stop := context.AfterFunc(ctx, func() {
+		ctrl.Close()
+	})
+	ctrl.SetDeadline(time.Now().Add(timeout))
+
+	relay, err := socks5UDPHandshake(ctrl, u)
+	if !stop() {
+		// ctx已取消，连接已被关闭
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+	ctrl.SetDeadline(time.Time{})
+
+	// 代理返回未指定地址时，中继地址与代理地址相同
+	relayAddr, err := net.ResolveUDPAddr("udp", relay)
+	if err != nil {
+		ctrl.Close()
+		return nil, errclass.Wrap(errclass.HandshakeRejected, err)
+	}
+	if relayAddr.IP == nil || relayAddr.IP.IsUnspecified() {
+		relayAddr.IP = ctrl.RemoteAddr().(*net.TCPAddr).IP
+	}
+
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+
+	a := &UDPAssociation{
+		Proxy:   proxyAddr,
+		ctrl:    ctrl,
+		conn:    conn,
+		relay:   relayAddr,
+		closeCh: make(chan struct{}),
+	}
+
+	// 控制连接断开即关闭关联
+	go func() {
+		io.Copy(io.Discard, ctrl)
+		a.Close()
+	}()
+
+	return a, nil
+}
+
+// socks5UDPHandshake 完成认证并发送UDP ASSOCIATE，返回中继地址
+func socks5UDPHandshake(conn net.Conn, u *url.URL) (string, error) {
+	methods := []byte{Socks5MethodNoAuth}
+	if u.User != nil {
+		methods = append(methods, Socks5MethodUserPass)
+	}
+	greeting := append([]byte{Socks5Version, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return "", err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return "", errclass.Wrap(errclass.HandshakeRejected, err)
+	}
+	if reply[0] != Socks5Version {
+		return "", errclass.New(errclass.HandshakeRejected, fmt.Sprintf("非预期的SOCKS版本: %d", reply[0]))
+	}
+
+	switch reply[1] {
+	case Socks5MethodNoAuth:
+	case Socks5MethodUserPass:
+		if u.User == nil {
+			return "", errclass.New(errclass.AuthRequired, "代理要求用户名密码认证")
+		}
+		user := u.User.Username()
+		pass, _ := u.User.Password()
+		if len(user) > 255 || len(pass) > 255 {
+			return "", errclass.New(errclass.Other, "用户名或密码过长")
+		}
+		auth := []byte{0x01, byte(len(user))}
+		auth = append(auth, user...)
+		auth = append(auth, byte(len(pass)))
+		auth = append(auth, pass...)
+		if _, err := conn.Write(auth); err != nil {
+			return "", err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return "", errclass.Wrap(errclass.HandshakeRejected, err)
+		}
+		if reply[1] != 0x00 {
+			return "", errclass.New(errclass.AuthRequired, "用户名密码认证失败")
+		}
+	default:
+		return "", errclass.New(errclass.AuthRequired, "没有可接受的认证方式")
+	}
+
+	// 客户端地址未知，按协议以全零地址发起关联
+	req := []byte{Socks5Version, Socks5CmdUDPAssociate, 0x00, Socks5AddrIPv4, 0, 0, 0, 0, 0, 0}
+	if _, err := conn.Write(req); err != nil {
+		return "", err
+	}
+
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", errclass.Wrap(errclass.HandshakeRejected, err)
+	}
+	if header[1] != Socks5RepSuccess {
+		return "", errclass.New(errclass.HandshakeRejected, fmt.Sprintf("代理拒绝UDP ASSOCIATE，应答码: %d", header[1]))
+	}
+	relay, err := ReadSocksAddr(conn)
+	if err != nil {
+		return "", errclass.Wrap(errclass.HandshakeRejected, err)
+	}
+	return relay, nil
+}
+
+// WriteTo 通过上游中继发送数据报到目标地址
+func (a *UDPAssociation) WriteTo(data []byte, address string) error {
+	pkt, err := PackUDPDatagram(address, data)
+	if err != nil {
+		return err
+	}
+	_, err = a.conn.WriteToUDP(pkt, a.relay)
+	return err
+}
+
+// datagramPool 复用读取中继数据报的缓冲，避免每个数据报分配64KB
+var datagramPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 65535)
+		return &buf
+	},
+}
+
+// ReadFrom 读取上游中继返回的数据报，返回数据长度和来源地址
+func (a *UDPAssociation) ReadFrom(buf []byte) (int, string, error) {
+	bp := datagramPool.Get().(*[]byte)
+	defer datagramPool.Put(bp)
+	pkt := *bp
+	for {
+		n, from, err := a.conn.ReadFromUDP(pkt)
+		if err != nil {
+			return 0, "", err
+		}
+		// 只接受来自中继地址的数据报
+		if !from.IP.Equal(a.relay.IP) {
+			continue
+		}
+		address, data, err := UnpackUDPDatagram(pkt[:n])
+		if err != nil {
+			continue
+		}
+		return copy(buf, data), address, nil
+	}
+}
+
+// SetReadDeadline 设置读取超时
+func (a *UDPAssociation) SetReadDeadline(t time.Time) error {
+	return a.conn.SetReadDeadline(t)
+}
+
+// Done 关联关闭时返回的通道会被关闭
+func (a *UDPAssociation) Done() <-chan struct{} {
+	return a.closeCh
+}
+
+// Close 关闭UDP关联
+func (a *UDPAssociation) Close() error {
+	a.once.Do(func() {
+		close(a.closeCh)
+		a.conn.Close()
+		a.ctrl.Close()
+	})
+	return nil
+}
+
+// TransmitUDPFromClient 按ctx中的选路参数从代理池中选择支持UDP的socks5代理建立UDP关联
+// 与TCP转发共用DialAttempts和DialBudget重试预算，ctx取消时立即返回
+func TransmitUDPFromClient(ctx context.Context, proxyStore pool.ProxyStore, timeout int) (*UDPAssociation, error) {
+	filter := RouteFromContext(ctx).Filter
+	filter.Protocol = "socks5"
+	filter.UDP = true
+	timeoutDur := time.Duration(timeout) * time.Second
+	deadline := time.Now().Add(DialBudget)
+
+	var lastErr error
+	for attempts := 0; attempts < DialAttempts; attempts++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		dialTimeout := timeoutDur
+		if remaining < dialTimeout {
+			dialTimeout = remaining
+		}
+
+		proxyAddr, err := pool.Select(proxyStore, filter)
+		if err != nil {
+			break
+		}
+		start := time.Now()
+		assoc, err := DialUDPViaProxyContext(ctx, proxyAddr, dialTimeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			lastErr = err
+			class := errclass.Classify(err)
+			pool.ReportDialFailure(proxyStore, proxyAddr, class)
+			logger.Info("%s UDP关联失败(%s)，自动切换下一个......", proxyAddr, class)
+			continue
+		}
+		pool.ReportDialSuccess(proxyAddr, time.Since(start))
+		return assoc, nil
+	}
+	if lastErr != nil {
+		return nil, fmt.Errorf("无可用的UDP代理: %w", lastErr)
+	}
+	return nil, errors.New("无可用的UDP代理")
+}
@@ -1,20 +1,25 @@
 package plugin
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/overflow0verture/proxy_harvester/internal/event"
 	"github.com/overflow0verture/proxy_harvester/internal/globals"
 	"github.com/overflow0verture/proxy_harvester/internal/logger"
+	"github.com/overflow0verture/proxy_harvester/internal/metrics"
 	"github.com/overflow0verture/proxy_harvester/internal/pool"
 	"github.com/overflow0verture/proxy_harvester/internal/requests"
 	"github.com/overflow0verture/proxy_harvester/internal/symbols"
+	"github.com/robfig/cron/v3"
 	"github.com/traefik/yaegi/interp"
 	"github.com/traefik/yaegi/stdlib"
-	"github.com/robfig/cron/v3"
-	"path/filepath"
-	"reflect"
-	"runtime"
-	"sync"
-	"time"
 )
 
 // ProxyProvider 是所有代理来源的统一接口
@@ -70,18 +75,18 @@ func SetGlobalPluginManager(manager *PluginManager) {
 func LoadPlugin(path string, proxyStore pool.ProxyStore) (ProxyProvider, *interp.Interpreter, error) {
 	// 设置yaegi的工作选项，特别是在Windows下
 	opts := interp.Options{
-		GoPath: "",  // 不使用GOPATH
+		GoPath: "",         // 不使用GOPATH
 		Env:    []string{}, // 清空环境变量，避免路径冲突
 	}
-	
+
 	i := interp.New(opts)
 
 	// 注册标准库符号
 	i.Use(stdlib.Symbols)
-	
+
 	// 初始化requests包，注入proxyStore
 	requests.InitRequests(proxyStore)
-	
+
 	// 使用symbols包中的符号表
 	i.Use(symbols.Symbols)
 
@@ -190,22 +195,9 @@ func StartAllPluginsWithCron(proxyStore pool.ProxyStore) {
 
 				logger.Plugin("开始执行 %s 插件的代理收集任务，执行前代理池有 %d 个代理", name, beforeCount)
 
-				// 创建有缓冲的通道接收代理
-				out := make(chan string, 1000)
-
-				// 启动收集器
-				go func() {
-					if err := provider.FetchProxies(out); err != nil {
-						logger.Error("%s 插件执行失败: %v", name, err)
-					}
-					close(out)
-				}()
-
-				// 启动转发到全局存储的协程
-				count := 0
-				for proxy := range out {
-					globals.ToCheckChan <- proxy
-					count++
+				count, err := runPlugin(name, provider)
+				if err != nil {
+					logger.Error("%s 插件执行失败: %v", name, err)
 				}
 
 				logger.Plugin("%s 插件已提交 %d 个代理到验证队列", name, count)
@@ -223,6 +215,68 @@ func StartAllPluginsWithCron(proxyStore pool.ProxyStore) {
 	pluginCron.Start()
 }
 
+var (
+	pluginRunDuration = metrics.NewHistogramVec("plugin_run_duration_seconds", "插件单次收集的耗时",
+		[]float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600}, "plugin")
+	pluginRunsTotal    = metrics.NewCounterVec("plugin_runs_total", "插件执行次数，按结果区分", "plugin", "result")
+	pluginProxiesTotal = metrics.NewCounterVec("plugin_proxies_total", "插件提交到验证队列的代理数", "plugin")
+)
+
+// 插件事件的动作
+const (
+	PluginStarted  = "started"
+	PluginFinished = "finished"
+)
+
+// PluginEvent 插件开始或结束一次收集
+type PluginEvent struct {
+	Plugin     string `json:"plugin"`
+	Action     string `json:"action"`
+	Count      int    `json:"count,omitempty"` // 提交到验证队列的代理数
+	DurationMs int64  `json:"duration_ms,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// runPlugin 执行一次插件的代理收集，将结果提交到验证队列
+// 返回提交的代理数和插件返回的错误
+func runPlugin(name string, provider ProxyProvider) (int, error) {
+	start := time.Now()
+	event.Publish(event.TypePlugin, PluginEvent{Plugin: name, Action: PluginStarted})
+
+	// 创建有缓冲的通道接收代理
+	out := make(chan string, 1000)
+	errCh := make(chan error, 1)
+
+	// 启动收集器
+	go func() {
+		defer close(out)
+		errCh <- provider.FetchProxies(out)
+	}()
+
+	// 转发到全局检测通道
+	count := 0
+	for proxy := range out {
+		globals.ToCheckChan <- globals.CheckTask{Proxy: proxy, Source: name}
+		count++
+	}
+	err := <-errCh
+
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	pluginRunDuration.ObserveDuration(time.Since(start), name)
+	pluginRunsTotal.Inc(name, result)
+	pluginProxiesTotal.Add(float64(count), name)
+
+	finished := PluginEvent{Plugin: name, Action: PluginFinished, Count: count, DurationMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		finished.Error = err.Error()
+	}
+	event.Publish(event.TypePlugin, finished)
+	return count, err
+}
+
 // ReloadPlugin 重新加载插件（文件变更时调用）
 func ReloadPlugin(path string, proxyStore pool.ProxyStore) error {
 	provider, interp, err := LoadPlugin(path, proxyStore)
@@ -258,22 +312,9 @@ func ReloadPlugin(path string, proxyStore pool.ProxyStore) error {
 	spec := provider.CronSpec()
 	if spec != "" {
 		cronID, err := pluginCron.AddFunc(spec, func() {
-			// 创建有缓冲的通道接收代理
-			out := make(chan string, 1000)
-
-			// 启动收集器
-			go func() {
-				defer close(out)
-				if err := provider.FetchProxies(out); err != nil {
-					logger.Error("%s 插件执行失败: %v", name, err)
-				}
-			}()
-
-			// 启动转发到全局存储的协程
-			count := 0
-			for proxy := range out {
-				globals.ToCheckChan <- proxy
-				count++
+			count, err := runPlugin(name, provider)
+			if err != nil {
+				logger.Error("%s 插件执行失败: %v", name, err)
 			}
 
 			logger.Plugin("%s 插件已提交 %d 个代理到验证队列", name, count)
@@ -292,22 +333,9 @@ func ReloadPlugin(path string, proxyStore pool.ProxyStore) error {
 	go func() {
 		logger.Plugin("立即执行一次 %s 插件的代理收集任务", name)
 
-		// 创建有缓冲的通道接收代理
-		out := make(chan string, 1000)
-
-		// 启动收集器
-		go func() {
-			defer close(out)
-			if err := provider.FetchProxies(out); err != nil {
-				logger.Error("%s 首次执行失败: %v", name, err)
-			}
-		}()
-
-		// 转发到全局检测通道
-		count := 0
-		for proxy := range out {
-			globals.ToCheckChan <- proxy
-			count++
+		count, err := runPlugin(name, provider)
+		if err != nil {
+			logger.Error("%s 首次执行失败: %v", name, err)
 		}
 
 		logger.Plugin("%s 首次已提交 %d 个代理到验证队列", name, count)
@@ -378,6 +406,22 @@ func RemovePluginByPath(path string) {
 	}()
 }
 
+// StopPluginSystem 停止插件目录监控和插件定时任务，并等待正在执行的收集任务结束
+func StopPluginSystem(ctx context.Context) error {
+	stopWatcher()
+
+	pluginMu.Lock()
+	stopped := pluginCron.Stop()
+	pluginMu.Unlock()
+
+	select {
+	case <-stopped.Done():
+		return nil
+	case <-ctx.Done():
+		return errors.New("等待插件收集任务结束超时")
+	}
+}
+
 // InitPluginSystem 初始化插件系统
 func InitPluginSystem() {
 	// 启动全局cron调度器
@@ -1,15 +1,16 @@
 package plugin
 
 import (
-	"github.com/fsnotify/fsnotify"
-	"github.com/overflow0verture/proxy_harvester/internal/config"
-	"github.com/overflow0verture/proxy_harvester/internal/logger"
-	"github.com/overflow0verture/proxy_harvester/internal/pool"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/overflow0verture/proxy_harvester/internal/config"
+	"github.com/overflow0verture/proxy_harvester/internal/logger"
+	"github.com/overflow0verture/proxy_harvester/internal/pool"
 )
 
 // 用于防止过于频繁的文件变更事件触发
@@ -36,6 +37,22 @@ func shouldProcessEvent(path string, minInterval time.Duration) bool {
 	return false
 }
 
+// 插件目录监控，关闭时停止
+var (
+	watcherMu     sync.Mutex
+	pluginWatcher *fsnotify.Watcher
+)
+
+// stopWatcher 停止插件目录监控
+func stopWatcher() {
+	watcherMu.Lock()
+	defer watcherMu.Unlock()
+	if pluginWatcher != nil {
+		pluginWatcher.Close()
+		pluginWatcher = nil
+	}
+}
+
 // 启动插件目录监控
 func WatchPluginFolder(cfg config.PluginConfig, proxyStore pool.ProxyStore) error {
 	pluginDir := cfg.PluginFolder
@@ -64,6 +81,9 @@ func WatchPluginFolder(cfg config.PluginConfig, proxyStore pool.ProxyStore) erro
 		watcher.Close()
 		return err
 	}
+	watcherMu.Lock()
+	pluginWatcher = watcher
+	watcherMu.Unlock()
 
 	logger.Plugin("文件监控已启动，将监控插件文件的变化")
 
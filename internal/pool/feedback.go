@@ -0,0 +1,114 @@
+package pool
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/overflow0verture/proxy_harvester/internal/errclass"
+	"github.com/overflow0verture/proxy_harvester/internal/event"
+	"github.com/overflow0verture/proxy_harvester/internal/logger"
+)
+
+// 客户端反馈参数
+const (
+	// DefaultFeedbackWeight 客户端反馈相对一次本地拨号结果的默认权重
+	DefaultFeedbackWeight = 0.5
+	// feedbackWindow 统计每个反馈方反馈量的时间窗口
+	feedbackWindow = time.Minute
+	// feedbackBudget 窗口内以完整权重计入的反馈次数，超出后权重按比例递减，避免单个客户端大量反馈清空代理池
+	feedbackBudget = 30
+)
+
+// Feedback 客户端反馈的代理使用结果
+type Feedback struct {
+	Proxy    string         `json:"proxy"`
+	Success  bool           `json:"success"`
+	Domain   string         `json:"domain,omitempty"` // 访问的目标域名
+	Class    errclass.Class `json:"class,omitempty"`  // 失败的错误分类
+	Reporter string         `json:"reporter"`         // 反馈方，用户令牌为用户名，管理令牌为admin
+	// Weight 实际计入的权重，重复反馈或被忽略时为0
+	Weight float64 `json:"weight"`
+	// Quarantined 此次反馈使代理被隔离
+	Quarantined bool `json:"quarantined,omitempty"`
+}
+
+// reporterWindow 单个反馈方在当前窗口内的反馈
+type reporterWindow struct {
+	start time.Time
+	count int
+	seen  map[string]bool // 代理|结果
+}
+
+var (
+	feedbackMu sync.Mutex
+	reporters  = make(map[string]*reporterWindow)
+)
+
+// reporterWeight 按反馈方在当前窗口内的反馈量计算实际权重
+// 同一反馈方在窗口内对同一代理的相同结果只计一次
+func reporterWeight(reporter, proxy string, success bool, base float64) float64 {
+	feedbackMu.Lock()
+	defer feedbackMu.Unlock()
+	now := time.Now()
+	w := reporters[reporter]
+	if w == nil || now.Sub(w.start) >= feedbackWindow {
+		w = &reporterWindow{start: now, seen: make(map[string]bool)}
+		reporters[reporter] = w
+	}
+	key := proxy + "|" + strconv.FormatBool(success)
+	if w.seen[key] {
+		return 0
+	}
+	w.seen[key] = true
+	w.count++
+	if w.count > feedbackBudget {
+		base *= float64(feedbackBudget) / float64(w.count)
+	}
+	return base
+}
+
+// ReportFeedback 按权重将客户端反馈计入健康分，失败反馈累计达到阈值时隔离
+// 客户端反馈只隔离不剔除，是否剔除仍由定期检测决定；base为反馈方的基础权重，不大于0时忽略
+func ReportFeedback(fb Feedback, base float64) Feedback {
+	if base <= 0 {
+		return fb
+	}
+	fb.Weight = reporterWeight(fb.Reporter, fb.Proxy, fb.Success, math.Min(base, 1))
+	if fb.Weight == 0 {
+		return fb
+	}
+
+	duration := dialQuarantine
+	if policy := errclass.PolicyFor(fb.Class); policy.Quarantine > duration {
+		duration = policy.Quarantine
+	}
+	until := time.Now().Add(duration)
+	metaMu.Lock()
+	m := getOrCreateMeta(fb.Proxy)
+	decay := scoreDecay * fb.Weight
+	if fb.Success {
+		m.Score = m.Score*(1-decay) + MaxScore*decay
+		m.ReportedFailures = math.Max(0, m.ReportedFailures-fb.Weight)
+	} else {
+		m.Score = m.Score * (1 - decay)
+		m.ReportedFailures += fb.Weight
+		if fb.Class != errclass.None {
+			m.LastError = fb.Class
+		}
+		if m.ReportedFailures >= failureThreshold {
+			m.QuarantineUntil = until
+			m.ReportedFailures = 0
+			fb.Quarantined = true
+		}
+	}
+	metaMu.Unlock()
+
+	event.Publish(event.TypeFeedback, fb)
+	if fb.Quarantined {
+		logger.ProxyPool("%s 多次被客户端反馈失败(%s)，隔离 %v", fb.Proxy, fb.Class, duration)
+		publishPool(PoolQuarantined, fb.Proxy, fb.Class, until)
+	}
+	return fb
+}
@@ -0,0 +1,103 @@
+package pool
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/overflow0verture/proxy_harvester/internal/errclass"
+)
+
+func TestReportFeedbackWeight(t *testing.T) {
+	proxy := "socks5://feedback-weight.test:1080"
+	tests := []struct {
+		name     string
+		reporter string
+		success  bool
+		base     float64
+		want     float64
+	}{
+		{"权重不大于0时忽略", "w-ignored", false, 0, 0},
+		{"负权重忽略", "w-negative", false, -1, 0},
+		{"默认权重", "w-default", false, DefaultFeedbackWeight, DefaultFeedbackWeight},
+		{"权重上限为1", "w-capped", true, 3, 1},
+	}
+	for _, tt := range tests {
+		fb := ReportFeedback(Feedback{Proxy: proxy, Success: tt.success, Reporter: tt.reporter}, tt.base)
+		if fb.Weight != tt.want {
+			t.Errorf("%s: 权重 = %v, want %v", tt.name, fb.Weight, tt.want)
+		}
+	}
+}
+
+func TestReportFeedbackDuplicate(t *testing.T) {
+	proxy := "socks5://feedback-dup.test:1080"
+	fb := Feedback{Proxy: proxy, Reporter: "dup"}
+	if got := ReportFeedback(fb, 1).Weight; got != 1 {
+		t.Fatalf("首次反馈权重 = %v", got)
+	}
+	if got := ReportFeedback(fb, 1).Weight; got != 0 {
+		t.Errorf("窗口内重复反馈权重 = %v, want 0", got)
+	}
+	fb.Success = true
+	if got := ReportFeedback(fb, 1).Weight; got != 1 {
+		t.Errorf("结果不同的反馈应计入，权重 = %v", got)
+	}
+}
+
+func TestReportFeedbackBudget(t *testing.T) {
+	for i := 1; i <= feedbackBudget+2; i++ {
+		fb := ReportFeedback(Feedback{Proxy: fmt.Sprintf("socks5://budget-%d.test:1080", i), Success: true, Reporter: "budget"}, 1)
+		want := 1.0
+		if i > feedbackBudget {
+			want = float64(feedbackBudget) / float64(i)
+		}
+		if math.Abs(fb.Weight-want) > 1e-9 {
+			t.Errorf("第%d次反馈权重 = %v, want %v", i, fb.Weight, want)
+		}
+	}
+}
+
+func TestReportFeedbackQuarantine(t *testing.T) {
+	proxy := "socks5://feedback-quarantine.test:1080"
+	report := func(reporter string, success bool, base float64) Feedback {
+		return ReportFeedback(Feedback{Proxy: proxy, Success: success, Class: errclass.ConnectRefused, Reporter: reporter}, base)
+	}
+
+	// 默认权重0.5下需要6次失败反馈才达到failureThreshold
+	for i := 0; i < 5; i++ {
+		if report(fmt.Sprintf("q-%d", i), false, DefaultFeedbackWeight).Quarantined {
+			t.Fatalf("第%d次失败反馈即被隔离", i+1)
+		}
+	}
+	// 成功反馈抵减失败累计
+	report("q-ok", true, DefaultFeedbackWeight)
+	if report("q-5", false, DefaultFeedbackWeight).Quarantined {
+		t.Fatal("成功反馈未抵减失败累计")
+	}
+	fb := report("q-6", false, DefaultFeedbackWeight)
+	if !fb.Quarantined || !IsQuarantined(proxy) {
+		t.Fatalf("失败累计达到阈值后应隔离: %+v", fb)
+	}
+	meta, _ := GetMeta(proxy)
+	if meta.ReportedFailures != 0 || meta.LastError != errclass.ConnectRefused {
+		t.Errorf("隔离后元信息 = %+v", meta)
+	}
+}
+
+func TestReportFeedbackScore(t *testing.T) {
+	proxy := "socks5://feedback-score.test:1080"
+	fb := ReportFeedback(Feedback{Proxy: proxy, Reporter: "score"}, DefaultFeedbackWeight)
+	meta, _ := GetMeta(proxy)
+	want := MaxScore * (1 - scoreDecay*fb.Weight)
+	if math.Abs(meta.Score-want) > 1e-9 {
+		t.Errorf("失败反馈后健康分 = %v, want %v", meta.Score, want)
+	}
+
+	ReportFeedback(Feedback{Proxy: proxy, Success: true, Reporter: "score"}, DefaultFeedbackWeight)
+	meta, _ = GetMeta(proxy)
+	want = want*(1-scoreDecay*DefaultFeedbackWeight) + MaxScore*scoreDecay*DefaultFeedbackWeight
+	if math.Abs(meta.Score-want) > 1e-9 {
+		t.Errorf("成功反馈后健康分 = %v, want %v", meta.Score, want)
+	}
+}
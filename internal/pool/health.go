@@ -0,0 +1,79 @@
+package pool
+
+import (
+	"time"
+
+	"github.com/overflow0verture/proxy_harvester/internal/errclass"
+	"github.com/overflow0verture/proxy_harvester/internal/logger"
+	"github.com/overflow0verture/proxy_harvester/internal/metrics"
+)
+
+// 健康分计算参数
+const (
+	// MaxScore 健康分上限，新加入或未使用过的代理为满分
+	MaxScore = 100.0
+	// scoreDecay 每次拨号结果在健康分中的权重
+	scoreDecay = 0.3
+	// failureThreshold 连续失败达到该次数时隔离
+	failureThreshold = 3
+	// dialQuarantine 拨号连续失败的隔离时长，由定期检测决定是否剔除
+	dialQuarantine = 2 * time.Minute
+)
+
+var (
+	dialsTotal        = metrics.NewCounterVec("upstream_dials_total", "经上游代理拨号的次数", "result")
+	dialFailuresTotal = metrics.NewCounterVec("upstream_dial_failures_total", "经上游代理拨号失败的次数", "class")
+)
+
+// ReportDialSuccess 记录一次成功的拨号
+func ReportDialSuccess(proxy string, latency time.Duration) {
+	dialsTotal.Inc("success")
+	metaMu.Lock()
+	defer metaMu.Unlock()
+	m := getOrCreateMeta(proxy)
+	m.Score = m.Score*(1-scoreDecay) + MaxScore*scoreDecay
+	m.Failures = 0
+	m.LastError = errclass.None
+	ms := latency.Milliseconds()
+	if m.LatencyMs == 0 {
+		m.LatencyMs = ms
+	} else {
+		m.LatencyMs = (m.LatencyMs*7 + ms*3) / 10
+	}
+}
+
+// ReportDialFailure 记录一次失败的拨号，只降低健康分，连续失败时隔离而不是剔除
+// 不再检测的错误分类（如需要认证）仍按HandleFailure处置
+func ReportDialFailure(store ProxyStore, proxy string, class errclass.Class) {
+	dialsTotal.Inc("failure")
+	dialFailuresTotal.Inc(string(class))
+	policy := errclass.PolicyFor(class)
+	if !policy.Recheck {
+		HandleFailure(store, proxy, class)
+		return
+	}
+
+	metaMu.Lock()
+	m := getOrCreateMeta(proxy)
+	m.Score = m.Score * (1 - scoreDecay)
+	m.Failures++
+	m.LastError = class
+	quarantine := m.Failures >= failureThreshold
+	duration := dialQuarantine
+	if policy.Quarantine > duration {
+		duration = policy.Quarantine
+	}
+	until := time.Now().Add(duration)
+	if quarantine {
+		m.QuarantineUntil = until
+		m.Failures = 0
+	}
+	source := m.Source
+	metaMu.Unlock()
+
+	errclass.Record(source, class)
+	if quarantine {
+		logger.ProxyPool("%s 连续拨号失败(%s)，隔离 %v", proxy, class, duration)
+		publishPool(PoolQuarantined, proxy, class, until)
+	}
+}
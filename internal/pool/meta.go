@@ -0,0 +1,283 @@
+package pool
+
+import (
+	"sync"
+	"time"
+
+	"github.com/overflow0verture/proxy_harvester/internal/errclass"
+	"github.com/overflow0verture/proxy_harvester/internal/event"
+	"github.com/overflow0verture/proxy_harvester/internal/logger"
+)
+
+// 不再检测的代理的屏蔽时长
+const banDuration = 24 * time.Hour
+
+// 代理匿名级别，由检测时的匿名度探测得到，按匿名程度从低到高
+const (
+	AnonymityTransparent = "transparent" // 目标可以看到客户端真实IP
+	AnonymityAnonymous   = "anonymous"   // 隐藏了真实IP，但请求头暴露了使用代理
+	AnonymityElite       = "elite"       // 目标无法察觉使用了代理
+)
+
+// 代理池变更事件的动作
+const (
+	PoolAdded       = "added"       // 检测通过入库
+	PoolRemoved     = "removed"     // 从代理池剔除
+	PoolQuarantined = "quarantined" // 隔离，到期前不参与选择
+)
+
+// PoolEvent 代理池变更事件
+type PoolEvent struct {
+	Action string         `json:"action"`
+	Proxy  string         `json:"proxy"`
+	Class  errclass.Class `json:"class,omitempty"` // 隔离的原因
+	Until  *time.Time     `json:"until,omitempty"` // 隔离截止时间
+}
+
+// publishPool 发布代理池变更事件
+func publishPool(action, proxy string, class errclass.Class, until time.Time) {
+	ev := PoolEvent{Action: action, Proxy: proxy, Class: class}
+	if !until.IsZero() {
+		ev.Until = &until
+	}
+	event.Publish(event.TypePool, ev)
+}
+
+// ProxyMeta 代理的附加信息（来源、隔离状态等），只保存在内存中
+type ProxyMeta struct {
+	Source          string         `json:"source,omitempty"`
+	Country         string         `json:"country,omitempty"` // 检测时从归属地接口解析的国家/地区
+	ASN             string         `json:"asn,omitempty"`     // 检测时从归属地接口解析的自治系统号
+	Anonymity       string         `json:"anonymity,omitempty"`
+	Labels          []string       `json:"labels,omitempty"`
+	LastError       errclass.Class `json:"last_error,omitempty"`
+	QuarantineUntil time.Time      `json:"quarantine_until,omitempty"`
+	BannedUntil     time.Time      `json:"banned_until,omitempty"`
+	TLSIntercepted  bool           `json:"tls_intercepted,omitempty"`
+	UDP             bool           `json:"udp,omitempty"`     // socks5代理支持UDP ASSOCIATE
+	Profile         string         `json:"profile,omitempty"` // 最近一次通过的检测方式
+	VerifiedAt      time.Time      `json:"verified_at"`       // 最近一次检测通过的时间
+	CheckLatencyMs  int64          `json:"check_latency_ms,omitempty"`
+	// Score 健康分(0-100)，按本地监听实际拨号的成败滑动计算
+	Score float64 `json:"score"`
+	// Failures 连续拨号失败次数
+	Failures int `json:"failures,omitempty"`
+	// LatencyMs 拨号耗时的滑动平均值
+	LatencyMs int64 `json:"latency_ms,omitempty"`
+	// ReportedFailures 客户端反馈失败的加权累计，成功反馈时抵减
+	ReportedFailures float64 `json:"reported_failures,omitempty"`
+
+	touched time.Time // 最近一次更新的时间，检测进行中尚未入库的代理据此不被清理
+}
+
+// metaGrace 最近更新过的附加信息在此时长内不被PruneMetas清理，需长于单次检测耗时
+const metaGrace = 10 * time.Minute
+
+var (
+	metaMu sync.RWMutex
+	metas  = make(map[string]*ProxyMeta)
+)
+
+// getOrCreateMeta 获取代理的附加信息，不存在则创建，调用方需持有metaMu写锁
+func getOrCreateMeta(proxy string) *ProxyMeta {
+	m, ok := metas[proxy]
+	if !ok {
+		m = &ProxyMeta{Score: MaxScore}
+		metas[proxy] = m
+	}
+	m.touched = time.Now()
+	return m
+}
+
+// pending 是否仍有未到期的屏蔽或隔离，调用方需持有metaMu
+func (m *ProxyMeta) pending(now time.Time) bool {
+	return now.Before(m.BannedUntil) || now.Before(m.QuarantineUntil)
+}
+
+// pruneMeta 代理离开代理池后删除其附加信息，屏蔽或隔离未到期时保留
+func pruneMeta(proxy string) {
+	metaMu.Lock()
+	defer metaMu.Unlock()
+	if m, ok := metas[proxy]; ok && !m.pending(time.Now()) {
+		delete(metas, proxy)
+	}
+}
+
+// PruneMetas 删除不在代理池中、屏蔽和隔离均已到期且近期未更新的代理附加信息，返回删除的数量
+func PruneMetas(store ProxyStore) int {
+	proxies, err := store.GetAll()
+	if err != nil {
+		return 0
+	}
+	inPool := make(map[string]bool, len(proxies))
+	for _, p := range proxies {
+		inPool[p] = true
+	}
+
+	now := time.Now()
+	metaMu.Lock()
+	defer metaMu.Unlock()
+	pruned := 0
+	for proxy, m := range metas {
+		if !inPool[proxy] && !m.pending(now) && now.Sub(m.touched) > metaGrace {
+			delete(metas, proxy)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// GetMeta 获取代理附加信息的副本
+func GetMeta(proxy string) (ProxyMeta, bool) {
+	metaMu.RLock()
+	defer metaMu.RUnlock()
+	m, ok := metas[proxy]
+	if !ok {
+		return ProxyMeta{}, false
+	}
+	meta := *m
+	meta.Labels = append([]string(nil), m.Labels...)
+	return meta, true
+}
+
+// SetSource 记录代理的来源插件
+func SetSource(proxy, source string) {
+	if source == "" {
+		return
+	}
+	metaMu.Lock()
+	defer metaMu.Unlock()
+	getOrCreateMeta(proxy).Source = source
+}
+
+// SetCountry 记录代理的国家/地区
+func SetCountry(proxy, country string) {
+	metaMu.Lock()
+	defer metaMu.Unlock()
+	getOrCreateMeta(proxy).Country = country
+}
+
+// AddLabels 为代理添加标签
+func AddLabels(proxy string, labels ...string) {
+	metaMu.Lock()
+	defer metaMu.Unlock()
+	m := getOrCreateMeta(proxy)
+	for _, label := range labels {
+		if label != "" && !hasLabel(m.Labels, label) {
+			m.Labels = append(m.Labels, label)
+		}
+	}
+}
+
+// SetTLSIntercepted 记录代理是否篡改HTTPS证书
+func SetTLSIntercepted(proxy string, intercepted bool) {
+	metaMu.Lock()
+	defer metaMu.Unlock()
+	getOrCreateMeta(proxy).TLSIntercepted = intercepted
+}
+
+// SetASN 记录代理出口IP的自治系统号
+func SetASN(proxy, asn string) {
+	metaMu.Lock()
+	defer metaMu.Unlock()
+	getOrCreateMeta(proxy).ASN = asn
+}
+
+// SetAnonymity 记录代理的匿名级别
+func SetAnonymity(proxy, level string) {
+	metaMu.Lock()
+	defer metaMu.Unlock()
+	getOrCreateMeta(proxy).Anonymity = level
+}
+
+// SetVerified 记录代理检测通过的时间、检测方式和检测耗时
+func SetVerified(proxy, profile string, latency time.Duration) {
+	metaMu.Lock()
+	defer metaMu.Unlock()
+	m := getOrCreateMeta(proxy)
+	m.Profile = profile
+	m.VerifiedAt = time.Now()
+	m.CheckLatencyMs = latency.Milliseconds()
+}
+
+// SetUDP 记录代理是否支持UDP转发
+func SetUDP(proxy string, supported bool) {
+	metaMu.Lock()
+	defer metaMu.Unlock()
+	getOrCreateMeta(proxy).UDP = supported
+}
+
+// IsQuarantined 代理当前是否处于隔离期
+func IsQuarantined(proxy string) bool {
+	metaMu.RLock()
+	defer metaMu.RUnlock()
+	m, ok := metas[proxy]
+	return ok && time.Now().Before(m.QuarantineUntil)
+}
+
+// IsBanned 代理当前是否被屏蔽（不再检测）
+func IsBanned(proxy string) bool {
+	metaMu.RLock()
+	defer metaMu.RUnlock()
+	m, ok := metas[proxy]
+	return ok && time.Now().Before(m.BannedUntil)
+}
+
+// MarkBad 手动标记代理不可用：从代理池剔除，屏蔽期内不再检测
+func MarkBad(store ProxyStore, proxy string) error {
+	metaMu.Lock()
+	getOrCreateMeta(proxy).BannedUntil = time.Now().Add(banDuration)
+	metaMu.Unlock()
+	return store.MarkInvalid(proxy)
+}
+
+// Unban 解除屏蔽，手动提交检测的代理不受之前的屏蔽影响
+func Unban(proxy string) {
+	metaMu.Lock()
+	defer metaMu.Unlock()
+	if m, ok := metas[proxy]; ok {
+		m.BannedUntil = time.Time{}
+	}
+}
+
+// HandleFailure 按错误分类的处置策略处理失败的代理：隔离、屏蔽或剔除
+func HandleFailure(store ProxyStore, proxy string, class errclass.Class) {
+	policy := errclass.PolicyFor(class)
+
+	metaMu.Lock()
+	m := getOrCreateMeta(proxy)
+	// 隔离后未曾成功又以同样原因失败的代理直接剔除，避免反复隔离
+	quarantine := policy.Quarantine > 0 && m.LastError != class
+	m.LastError = class
+	until := time.Now().Add(policy.Quarantine)
+	if quarantine {
+		m.QuarantineUntil = until
+	} else {
+		m.QuarantineUntil = time.Time{}
+	}
+	if !policy.Recheck {
+		m.BannedUntil = time.Now().Add(banDuration)
+	}
+	source := m.Source
+	metaMu.Unlock()
+
+	errclass.Record(source, class)
+
+	if quarantine {
+		logger.ProxyPool("%s 因 %s 被隔离 %v", proxy, class, policy.Quarantine)
+		publishPool(PoolQuarantined, proxy, class, until)
+		return
+	}
+	store.MarkInvalid(proxy)
+}
+
+// HandleSuccess 代理检测或使用成功，解除隔离
+func HandleSuccess(proxy string) {
+	metaMu.Lock()
+	defer metaMu.Unlock()
+	if m, ok := metas[proxy]; ok {
+		m.LastError = errclass.None
+		m.QuarantineUntil = time.Time{}
+		m.Failures = 0
+	}
+}
@@ -1,16 +1,18 @@
 package pool
 
 import (
-	"github.com/overflow0verture/proxy_harvester/internal/config"
-	"github.com/overflow0verture/proxy_harvester/internal/logger"
 	"bufio"
 	"context"
 	"fmt"
-	"github.com/go-redis/redis/v8"
 	"os"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/overflow0verture/proxy_harvester/internal/config"
+	"github.com/overflow0verture/proxy_harvester/internal/errclass"
+	"github.com/overflow0verture/proxy_harvester/internal/logger"
 )
 
 // ProxyStore 代理池统一接口，支持本地文件和Redis两种实现
@@ -29,8 +31,7 @@ type FileProxyStore struct {
 	proxies  []string
 	index    int
 	mu       sync.Mutex
-	tokenMap map[string]chan struct{}
-	rate     int
+	tokens   *tokenLimiter
 	filename string // 新增字段
 }
 
@@ -39,16 +40,15 @@ func NewFileProxyStore(filename string, rate int) *FileProxyStore {
 	store := &FileProxyStore{
 		proxies:  make([]string, 0),
 		index:    0,
-		tokenMap: make(map[string]chan struct{}),
+		tokens:   newTokenLimiter(rate),
 		filename: filename,
-		rate:     rate,
 	}
-	
+
 	// 尝试从文件加载现有代理
 	if err := store.loadFromFile(); err != nil {
 		logger.Error("无法从文件加载代理: %v", err)
 	}
-	
+
 	return store
 }
 
@@ -56,7 +56,7 @@ func NewFileProxyStore(filename string, rate int) *FileProxyStore {
 func (s *FileProxyStore) loadFromFile() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	
+
 	file, err := os.Open(s.filename)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -65,13 +65,13 @@ func (s *FileProxyStore) loadFromFile() error {
 		return err
 	}
 	defer file.Close()
-	
+
 	scanner := bufio.NewScanner(file)
 	count := 0
-	
+
 	// 清空现有代理列表
 	s.proxies = make([]string, 0)
-	
+
 	for scanner.Scan() {
 		proxy := strings.TrimSpace(scanner.Text())
 		if proxy != "" {
@@ -79,31 +79,46 @@ func (s *FileProxyStore) loadFromFile() error {
 			count++
 		}
 	}
-	
+
 	if err := scanner.Err(); err != nil {
 		return err
 	}
-	
+
 	return nil
 }
 
-// 保存代理列表到文件
+// 保存代理列表到文件，先写临时文件再替换，避免写入中途退出导致文件损坏
 func (s *FileProxyStore) saveToFile() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	
-	file, err := os.Create(s.filename)
+
+	tmp := s.filename + ".tmp"
+	file, err := os.Create(tmp)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	
+
 	writer := bufio.NewWriter(file)
 	for _, proxy := range s.proxies {
 		fmt.Fprintln(writer, proxy)
 	}
-	
-	return writer.Flush()
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.filename)
+}
+
+// Close 关闭前保存代理列表，等待进行中的保存完成
+func (s *FileProxyStore) Close() error {
+	return s.saveToFile()
 }
 
 // Add 添加代理并保存到文件
@@ -112,9 +127,9 @@ func (s *FileProxyStore) Add(proxy string) error {
 	if proxy == "" {
 		return nil
 	}
-	
+
 	s.mu.Lock()
-	
+
 	// 检查重复
 	for _, p := range s.proxies {
 		if p == proxy {
@@ -122,10 +137,11 @@ func (s *FileProxyStore) Add(proxy string) error {
 			return nil
 		}
 	}
-	
+
 	s.proxies = append(s.proxies, proxy)
 	s.mu.Unlock()
-	
+	publishPool(PoolAdded, proxy, errclass.None, time.Time{})
+
 	// 每次添加都保存文件可能性能差，可以考虑延迟批量保存
 	return s.saveToFile()
 }
@@ -133,7 +149,7 @@ func (s *FileProxyStore) Add(proxy string) error {
 // Remove 删除代理并更新文件
 func (s *FileProxyStore) Remove(proxy string) error {
 	s.mu.Lock()
-	
+
 	found := false
 	for i, p := range s.proxies {
 		if p == proxy {
@@ -143,13 +159,16 @@ func (s *FileProxyStore) Remove(proxy string) error {
 			break
 		}
 	}
-	
+
 	s.mu.Unlock()
-	
+
+	pruneMeta(proxy)
 	if found {
+		s.tokens.forget(proxy)
+		publishPool(PoolRemoved, proxy, errclass.None, time.Time{})
 		return s.saveToFile()
 	}
-	
+
 	return nil
 }
 
@@ -157,59 +176,41 @@ func (s *FileProxyStore) Remove(proxy string) error {
 func (s *FileProxyStore) GetAll() ([]string, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	
+
 	result := make([]string, len(s.proxies))
 	copy(result, s.proxies)
-	
+
 	return result, nil
 }
 
 // GetNext 获取下一个可用代理（带令牌桶限速）
 func (s *FileProxyStore) GetNext() (string, error) {
 	s.mu.Lock()
-	
+
 	if len(s.proxies) == 0 {
 		s.mu.Unlock()
 		return "", fmt.Errorf("代理池为空")
 	}
-	
-	// 轮询选择下一个代理
-	s.index = (s.index + 1) % len(s.proxies)
-	proxy := s.proxies[s.index]
-	
+
+	// 轮询选择下一个代理，跳过隔离中的代理
+	proxy := ""
+	for i := 0; i < len(s.proxies); i++ {
+		s.index = (s.index + 1) % len(s.proxies)
+		if !IsQuarantined(s.proxies[s.index]) {
+			proxy = s.proxies[s.index]
+			break
+		}
+	}
+
 	s.mu.Unlock()
-	
+
+	if proxy == "" {
+		return "", fmt.Errorf("代理池中的代理均处于隔离期")
+	}
+
 	// 限速控制：确保每个代理的使用不超过指定速率
-	tokenCh, exists := s.tokenMap[proxy]
-	if !exists {
-		// 为此代理创建令牌桶
-		tokenCh = make(chan struct{}, s.rate)
-		s.tokenMap[proxy] = tokenCh
-		
-		// 初始填充令牌
-		for i := 0; i < s.rate; i++ {
-			tokenCh <- struct{}{}
-		}
-		
-		// 启动令牌生成器
-		go func(ch chan struct{}) {
-			ticker := time.NewTicker(time.Second / time.Duration(s.rate))
-			defer ticker.Stop()
-			
-			for range ticker.C {
-				select {
-				case ch <- struct{}{}:
-					// 添加成功
-				default:
-					// 桶已满，跳过
-				}
-			}
-		}(tokenCh)
-	}
-	
-	// 获取令牌
-	<-tokenCh
-	
+	s.tokens.take(proxy)
+
 	return proxy, nil
 }
 
@@ -222,19 +223,28 @@ func (s *FileProxyStore) MarkInvalid(proxy string) error {
 func (s *FileProxyStore) Len() (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	
+
 	return len(s.proxies), nil
 }
 
+// redisCacheTTL Redis代理列表本地缓存的有效期，过期后重新读取，以发现其他实例的增删
+const redisCacheTTL = 30 * time.Second
+
 // Redis实现
 type RedisProxyStore struct {
-	client  *redis.Client
-	key     string
-	ctx     context.Context
-	index   int
-	mu      sync.Mutex
-	proxies []string // 缓存
-	rate    int
+	client   *redis.Client
+	key      string
+	ctx      context.Context
+	index    int
+	mu       sync.Mutex
+	proxies  []string  // 缓存
+	loadedAt time.Time // 缓存从Redis读取的时间
+	tokens   *tokenLimiter
+}
+
+// Close 关闭Redis连接
+func (s *RedisProxyStore) Close() error {
+	return s.client.Close()
 }
 
 // 新建Redis代理池
@@ -244,24 +254,25 @@ func NewRedisProxyStore(host string, port int, password string, rate int) *Redis
 		Password: password,
 		DB:       0,
 	})
-	
+
 	store := &RedisProxyStore{
 		client:  client,
 		key:     "proxy_pool",
 		ctx:     context.Background(),
 		index:   0,
 		proxies: make([]string, 0),
-		rate:    rate,
+		tokens:  newTokenLimiter(rate),
 	}
-	
+
 	// 初始加载缓存
 	if members, err := client.SMembers(store.ctx, store.key).Result(); err == nil {
 		store.proxies = members
+		store.loadedAt = time.Now()
 		logger.ProxyPool("已从Redis加载 %d 个代理", len(members))
 	} else {
 		logger.Error("从Redis加载代理失败: %v", err)
 	}
-	
+
 	return store
 }
 
@@ -271,28 +282,29 @@ func (s *RedisProxyStore) Add(proxy string) error {
 	if proxy == "" {
 		return nil
 	}
-	
+
 	// 先检查是否已存在
 	if s.client.SIsMember(s.ctx, s.key, proxy).Val() {
 		return nil
 	}
-	
+
 	// 添加到Redis
 	err := s.client.SAdd(s.ctx, s.key, proxy).Err()
 	if err != nil {
 		return err
 	}
-	
+	publishPool(PoolAdded, proxy, errclass.None, time.Time{})
+
 	// 更新本地缓存
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	
+
 	for _, p := range s.proxies {
 		if p == proxy {
 			return nil // 已在缓存中
 		}
 	}
-	
+
 	s.proxies = append(s.proxies, proxy)
 	return nil
 }
@@ -300,19 +312,26 @@ func (s *RedisProxyStore) Add(proxy string) error {
 // Remove 从Redis删除代理
 func (s *RedisProxyStore) Remove(proxy string) error {
 	// 从Redis删除
-	err := s.client.SRem(s.ctx, s.key, proxy).Err()
-	
+	removed, err := s.client.SRem(s.ctx, s.key, proxy).Result()
+	if err == nil {
+		pruneMeta(proxy)
+	}
+	if removed > 0 {
+		s.tokens.forget(proxy)
+		publishPool(PoolRemoved, proxy, errclass.None, time.Time{})
+	}
+
 	// 更新本地缓存
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	
+
 	for i, p := range s.proxies {
 		if p == proxy {
 			s.proxies = append(s.proxies[:i], s.proxies[i+1:]...)
 			break
 		}
 	}
-	
+
 	return err
 }
 
@@ -321,28 +340,71 @@ func (s *RedisProxyStore) GetAll() ([]string, error) {
 	return s.client.SMembers(s.ctx, s.key).Result()
 }
 
-// GetNext 获取下一个Redis代理
+// GetNext 获取下一个Redis代理（带令牌桶限速）
 func (s *RedisProxyStore) GetNext() (string, error) {
+	proxy, err := s.next()
+	if err != nil {
+		return "", err
+	}
+	s.tokens.take(proxy)
+	return proxy, nil
+}
+
+// next 轮询选择下一个未隔离的代理
+func (s *RedisProxyStore) next() (string, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	
-	if len(s.proxies) == 0 {
-		// 尝试重新加载
-		members, err := s.client.SMembers(s.ctx, s.key).Result()
-		if err != nil {
-			return "", fmt.Errorf("代理池为空且无法重新加载: %v", err)
+
+	if err := s.refresh(); err != nil {
+		return "", err
+	}
+
+	// 轮询选择下一个代理，跳过隔离中的代理
+	for i := 0; i < len(s.proxies); i++ {
+		s.index = (s.index + 1) % len(s.proxies)
+		if !IsQuarantined(s.proxies[s.index]) {
+			return s.proxies[s.index], nil
 		}
-		
-		if len(members) == 0 {
-			return "", fmt.Errorf("代理池为空")
+	}
+	return "", fmt.Errorf("代理池中的代理均处于隔离期")
+}
+
+// refresh 缓存为空或过期时从Redis重新加载，读取失败时继续使用旧缓存，调用方需持有s.mu
+func (s *RedisProxyStore) refresh() error {
+	if len(s.proxies) > 0 && time.Since(s.loadedAt) < redisCacheTTL {
+		return nil
+	}
+	members, err := s.client.SMembers(s.ctx, s.key).Result()
+	if err != nil {
+		if len(s.proxies) > 0 {
+			return nil
 		}
-		
-		s.proxies = members
+		return fmt.Errorf("代理池为空且无法重新加载: %v", err)
+	}
+	s.proxies = members
+	s.loadedAt = time.Now()
+	if s.index >= len(s.proxies) {
+		s.index = 0
+	}
+	if len(members) == 0 {
+		return fmt.Errorf("代理池为空")
 	}
-	
-	// 轮询选择下一个代理
-	s.index = (s.index + 1) % len(s.proxies)
-	return s.proxies[s.index], nil
+	return nil
+}
+
+// cachedAll 本地缓存的代理列表副本，按筛选条件选择代理时使用
+func (s *RedisProxyStore) cachedAll() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	return append([]string(nil), s.proxies...), nil
+}
+
+// has 代理是否在Redis中，只查询单个成员
+func (s *RedisProxyStore) has(proxy string) (bool, error) {
+	return s.client.SIsMember(s.ctx, s.key, proxy).Result()
 }
 
 // MarkInvalid 标记Redis代理无效并剔除
@@ -355,6 +417,14 @@ func (s *RedisProxyStore) Len() (int, error) {
 	return int(s.client.SCard(s.ctx, s.key).Val()), nil
 }
 
+// CloseStore 刷新并关闭代理池，存储实现不需要关闭时直接返回
+func CloseStore(store ProxyStore) error {
+	if closer, ok := store.(interface{ Close() error }); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
 func InitProxyStore(cfg config.StorageConfig, rate int) ProxyStore {
 	if cfg.Type == "redis" {
 		logger.Info("使用Redis作为代理池存储")
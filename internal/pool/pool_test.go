@@ -0,0 +1,28 @@
+package pool
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/overflow0verture/proxy_harvester/internal/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.Setup(false, "")
+	m.Run()
+}
+
+// newTestStore 创建不限速的本地文件代理池，代理地址按name区分，避免与其他测试共享元信息
+func newTestStore(t *testing.T, name string, n int) (*FileProxyStore, []string) {
+	t.Helper()
+	store := NewFileProxyStore(filepath.Join(t.TempDir(), "proxies.txt"), 0)
+	proxies := make([]string, n)
+	for i := range proxies {
+		proxies[i] = fmt.Sprintf("socks5://%s-%d.test:1080", name, i)
+		if err := store.Add(proxies[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store, proxies
+}
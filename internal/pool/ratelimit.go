@@ -0,0 +1,79 @@
+package pool
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenLimiter 按代理分别限速的令牌桶，每个代理每秒最多使用rate次
+// 所有选择策略选中代理后都经过take，保证每次选择都消耗一个令牌
+type tokenLimiter struct {
+	mu      sync.Mutex
+	rate    int
+	buckets map[string]*bucket
+}
+
+// bucket 单个代理的令牌桶，桶容量为一秒的使用次数
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newTokenLimiter(rate int) *tokenLimiter {
+	return &tokenLimiter{rate: rate, buckets: make(map[string]*bucket)}
+}
+
+// take 消耗代理的一个令牌，令牌不足时等待，rate<=0时不限速
+func (l *tokenLimiter) take(proxy string) {
+	if l == nil || l.rate <= 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	rate := float64(l.rate)
+	b, ok := l.buckets[proxy]
+	if !ok {
+		b = &bucket{tokens: rate, last: now}
+		l.buckets[proxy] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > rate {
+		b.tokens = rate
+	}
+	b.last = now
+	b.tokens--
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// forget 代理移出代理池时删除其令牌桶
+func (l *tokenLimiter) forget(proxy string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	delete(l.buckets, proxy)
+	l.mu.Unlock()
+}
+
+// rateLimited 支持按代理限速的存储
+type rateLimited interface {
+	limiter() *tokenLimiter
+}
+
+func (s *FileProxyStore) limiter() *tokenLimiter  { return s.tokens }
+func (s *RedisProxyStore) limiter() *tokenLimiter { return s.tokens }
+
+// takeToken 为选中的代理消耗一个令牌，存储不支持限速时直接返回
+func takeToken(store ProxyStore, proxy string) {
+	if l, ok := store.(rateLimited); ok {
+		l.limiter().take(proxy)
+	}
+}
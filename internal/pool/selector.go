@@ -0,0 +1,265 @@
+package pool
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 选择策略
+const (
+	StrategyRoundRobin = "round_robin" // 轮询（默认）
+	StrategyWeighted   = "weighted"    // 按健康分加权随机
+	StrategyRandom     = "random"      // 随机
+	StrategySticky     = "sticky"      // 同一客户端固定使用同一个上游，由监听按客户端地址生成会话
+)
+
+// ValidStrategy 是否为支持的选择策略，空表示默认轮询
+func ValidStrategy(strategy string) bool {
+	switch strategy {
+	case "", StrategyRoundRobin, StrategyWeighted, StrategyRandom, StrategySticky:
+		return true
+	}
+	return false
+}
+
+// Filter 代理筛选条件，字段为空表示不限
+type Filter struct {
+	Country  string   `json:"country,omitempty"`  // 国家/地区，与检测得到的归属地比较，不区分大小写
+	Protocol string   `json:"protocol,omitempty"` // 协议，socks5/http/https
+	Label    string   `json:"label,omitempty"`    // 标签，匹配代理的标签或来源插件名
+	Labels   []string `json:"labels,omitempty"`   // 匹配其中任一标签或来源插件名
+	Profile  string   `json:"profile,omitempty"`  // 通过的检测方式，default/geolocate
+	UDP      bool     `json:"udp,omitempty"`      // 只选择检测确认支持UDP转发的代理
+}
+
+// IsZero 是否未设置任何筛选条件
+func (f Filter) IsZero() bool {
+	return f.Country == "" && f.Protocol == "" && f.Label == "" && len(f.Labels) == 0 && f.Profile == "" && !f.UDP
+}
+
+// Key 筛选条件的唯一标识，用于区分轮询游标
+func (f Filter) Key() string {
+	return strings.ToLower(f.Country) + "|" + strings.ToLower(f.Protocol) + "|" + f.Label + "|" +
+		strings.Join(f.Labels, ",") + "|" + f.Profile + "|" + strconv.FormatBool(f.UDP)
+}
+
+// Match 判断代理是否满足筛选条件
+func (f Filter) Match(proxy string) bool {
+	if f.Protocol != "" && !strings.HasPrefix(proxy, strings.ToLower(f.Protocol)+"://") {
+		return false
+	}
+	if f.Country == "" && f.Label == "" && len(f.Labels) == 0 && f.Profile == "" && !f.UDP {
+		return true
+	}
+
+	meta, _ := GetMeta(proxy)
+	if f.Country != "" && !strings.EqualFold(meta.Country, f.Country) {
+		return false
+	}
+	if f.Label != "" && meta.Source != f.Label && !hasLabel(meta.Labels, f.Label) {
+		return false
+	}
+	if len(f.Labels) > 0 {
+		matched := false
+		for _, label := range f.Labels {
+			if meta.Source == label || hasLabel(meta.Labels, label) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if f.Profile != "" && meta.Profile != f.Profile {
+		return false
+	}
+	if f.UDP && !meta.UDP {
+		return false
+	}
+	return true
+}
+
+func hasLabel(labels []string, label string) bool {
+	for _, l := range labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// maxCursors 轮询游标的数量上限，筛选条件可能来自客户端用户名，不能无限增长
+const maxCursors = 1024
+
+// cursor 某个筛选条件的轮询位置
+type cursor struct {
+	idx  int
+	used time.Time
+}
+
+// 按筛选条件分别记录的轮询游标
+var (
+	cursorMu sync.Mutex
+	cursors  = make(map[string]*cursor)
+)
+
+// Select 按筛选条件轮询选择代理，未设置条件时等同于GetNext
+func Select(store ProxyStore, f Filter) (string, error) {
+	if f.IsZero() {
+		return store.GetNext()
+	}
+
+	candidates, err := matching(store, f)
+	if err != nil {
+		return "", err
+	}
+
+	idx := nextCursor(f.Key(), len(candidates))
+	takeToken(store, candidates[idx])
+	return candidates[idx], nil
+}
+
+// nextCursor 推进筛选条件的轮询游标，返回本次选择的下标
+// 游标数达到上限时先删除最久未使用的一半
+func nextCursor(key string, n int) int {
+	cursorMu.Lock()
+	defer cursorMu.Unlock()
+	c, ok := cursors[key]
+	if !ok {
+		if len(cursors) >= maxCursors {
+			evictCursors(maxCursors / 2)
+		}
+		c = &cursor{}
+		cursors[key] = c
+	}
+	c.idx = (c.idx + 1) % n
+	c.used = time.Now()
+	return c.idx
+}
+
+// evictCursors 删除最久未使用的n个游标，调用方需持有cursorMu
+func evictCursors(n int) {
+	keys := make([]string, 0, len(cursors))
+	for key := range cursors {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return cursors[keys[i]].used.Before(cursors[keys[j]].used)
+	})
+	if n > len(keys) {
+		n = len(keys)
+	}
+	for _, key := range keys[:n] {
+		delete(cursors, key)
+	}
+}
+
+// SelectBy 按筛选条件和选择策略选择代理，轮询和粘性策略等同于Select
+// 无论哪种策略，选中的代理都消耗一个限速令牌
+func SelectBy(store ProxyStore, f Filter, strategy string) (string, error) {
+	switch strategy {
+	case StrategyRandom:
+		candidates, err := matching(store, f)
+		if err != nil {
+			return "", err
+		}
+		proxy := candidates[rand.Intn(len(candidates))]
+		takeToken(store, proxy)
+		return proxy, nil
+	case StrategyWeighted:
+		candidates, err := matching(store, f)
+		if err != nil {
+			return "", err
+		}
+		proxy := weightedPick(candidates)
+		takeToken(store, proxy)
+		return proxy, nil
+	default:
+		return Select(store, f)
+	}
+}
+
+// matching 满足筛选条件且未被隔离的代理
+func matching(store ProxyStore, f Filter) ([]string, error) {
+	proxies, err := allProxies(store)
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]string, 0, len(proxies))
+	for _, p := range proxies {
+		if f.Match(p) && !IsQuarantined(p) {
+			candidates = append(candidates, p)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("没有满足条件的代理")
+	}
+	return candidates, nil
+}
+
+// weightedPick 按健康分加权随机选择，未记录健康分的代理按满分计算
+func weightedPick(candidates []string) string {
+	weights := make([]float64, len(candidates))
+	total := 0.0
+	metaMu.RLock()
+	for i, p := range candidates {
+		w := MaxScore
+		if m, ok := metas[p]; ok {
+			w = m.Score
+		}
+		// 保留最低权重，健康分降到0的代理仍有机会恢复
+		if w < 1 {
+			w = 1
+		}
+		weights[i] = w
+		total += w
+	}
+	metaMu.RUnlock()
+
+	r := rand.Float64() * total
+	for i, w := range weights {
+		if r < w {
+			return candidates[i]
+		}
+		r -= w
+	}
+	return candidates[len(candidates)-1]
+}
+
+// cachedStore 在本地缓存代理列表的存储，每次连接选择代理时使用缓存，不必读取完整代理池
+type cachedStore interface {
+	cachedAll() ([]string, error) // 缓存的代理列表副本
+	has(proxy string) (bool, error)
+}
+
+// allProxies 选择代理用的代理列表，存储有缓存时使用缓存
+func allProxies(store ProxyStore) ([]string, error) {
+	if cs, ok := store.(cachedStore); ok {
+		return cs.cachedAll()
+	}
+	return store.GetAll()
+}
+
+// contains 代理是否仍在代理池中
+func contains(store ProxyStore, proxy string) bool {
+	if cs, ok := store.(cachedStore); ok {
+		found, err := cs.has(proxy)
+		return err == nil && found
+	}
+	proxies, err := store.GetAll()
+	if err != nil {
+		return false
+	}
+	for _, p := range proxies {
+		if p == proxy {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package pool
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestSelectRoundRobin(t *testing.T) {
+	store, proxies := newTestStore(t, "roundrobin", 3)
+	f := Filter{Protocol: "socks5"}
+	seen := make(map[string]int)
+	for i := 0; i < 3*len(proxies); i++ {
+		proxy, err := Select(store, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[proxy]++
+	}
+	for _, p := range proxies {
+		if seen[p] != 3 {
+			t.Errorf("%s 被选中 %d 次, want 3", p, seen[p])
+		}
+	}
+}
+
+func TestNextCursorBounded(t *testing.T) {
+	cursorMu.Lock()
+	saved := cursors
+	cursors = make(map[string]*cursor)
+	cursorMu.Unlock()
+	t.Cleanup(func() {
+		cursorMu.Lock()
+		cursors = saved
+		cursorMu.Unlock()
+	})
+
+	nextCursor("hot", 2)
+	for i := 0; i < 3*maxCursors; i++ {
+		nextCursor(strconv.Itoa(i), 2)
+		if i%100 == 0 {
+			// 持续使用的游标不会被淘汰
+			nextCursor("hot", 2)
+		}
+	}
+	cursorMu.Lock()
+	n, hot := len(cursors), cursors["hot"]
+	cursorMu.Unlock()
+	if n > maxCursors {
+		t.Errorf("游标数 = %d, 超过上限 %d", n, maxCursors)
+	}
+	if hot == nil {
+		t.Error("常用的游标被淘汰")
+	}
+}
+
+// countingStore 带本地缓存的存储，记录读取完整代理池的次数
+type countingStore struct {
+	*FileProxyStore
+	getAll int
+}
+
+func (s *countingStore) GetAll() ([]string, error) {
+	s.getAll++
+	return s.FileProxyStore.GetAll()
+}
+
+func (s *countingStore) cachedAll() ([]string, error) {
+	return s.FileProxyStore.GetAll()
+}
+
+func (s *countingStore) has(proxy string) (bool, error) {
+	proxies, _ := s.FileProxyStore.GetAll()
+	for _, p := range proxies {
+		if p == proxy {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestSelectUsesCache(t *testing.T) {
+	file, _ := newTestStore(t, "cached", 3)
+	store := &countingStore{FileProxyStore: file}
+	f := Filter{Protocol: "socks5"}
+	for _, strategy := range []string{StrategyRoundRobin, StrategyRandom, StrategyWeighted} {
+		if _, err := SelectBy(store, f, strategy); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 粘性会话复用绑定的代理时只判断单个成员
+	for i := 0; i < 2; i++ {
+		if _, err := SelectStickyBy(store, f, "", "cached-session"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	RevokeSession("cached-session")
+	if store.getAll != 0 {
+		t.Errorf("选择代理读取了 %d 次完整代理池", store.getAll)
+	}
+}
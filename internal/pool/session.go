@@ -0,0 +1,187 @@
+package pool
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/overflow0verture/proxy_harvester/internal/event"
+	"github.com/overflow0verture/proxy_harvester/internal/logger"
+)
+
+// DefaultSessionTTL 默认的会话租约时长
+const DefaultSessionTTL = 10 * time.Minute
+
+// 会话事件动作
+const (
+	SessionCreated  = "created"  // 新建租约
+	SessionFailover = "failover" // 绑定的代理失效，切换到新的代理
+	SessionExpired  = "expired"  // 租约到期
+	SessionRevoked  = "revoked"  // 租约被撤销
+)
+
+// Lease 粘性会话租约：租约有效期内同一会话ID固定使用同一个上游代理
+type Lease struct {
+	Session   string    `json:"session"`
+	Proxy     string    `json:"proxy"`
+	Filter    Filter    `json:"filter"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Failovers int       `json:"failovers"` // 因代理失效切换的次数
+	failed    bool      // 绑定的代理拨号失败，下次使用时切换
+}
+
+// SessionEvent 会话租约变更事件
+type SessionEvent struct {
+	Session  string `json:"session"`
+	Action   string `json:"action"`
+	Proxy    string `json:"proxy,omitempty"`
+	Previous string `json:"previous,omitempty"` // 故障切换前的代理
+}
+
+var (
+	sessionMu  sync.Mutex
+	sessions   = make(map[string]*Lease)
+	sessionTTL = DefaultSessionTTL
+)
+
+// SetSessionTTL 设置会话租约时长，小于等于0时使用默认值
+func SetSessionTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = DefaultSessionTTL
+	}
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	sessionTTL = ttl
+}
+
+// SelectSticky 为会话选择代理，租约有效且代理仍可用时直接复用
+// 绑定的代理失效时切换到新的代理并保留租约到期时间，session为空时等同于Select
+func SelectSticky(store ProxyStore, f Filter, session string) (string, error) {
+	return SelectStickyBy(store, f, StrategyRoundRobin, session)
+}
+
+// SelectStickyBy 同SelectSticky，新建或切换租约时按strategy选择代理
+func SelectStickyBy(store ProxyStore, f Filter, strategy, session string) (string, error) {
+	if session == "" {
+		return SelectBy(store, f, strategy)
+	}
+
+	now := time.Now()
+	sessionMu.Lock()
+	lease, ok := sessions[session]
+	if ok && now.After(lease.ExpiresAt) {
+		delete(sessions, session)
+		ok = false
+		publishSession(session, SessionExpired, lease.Proxy, "")
+	}
+	var (
+		pinned string
+		failed bool
+	)
+	if ok {
+		pinned, failed = lease.Proxy, lease.failed
+	}
+	sessionMu.Unlock()
+
+	if ok && !failed && f.Match(pinned) && !IsQuarantined(pinned) && contains(store, pinned) {
+		takeToken(store, pinned)
+		return pinned, nil
+	}
+
+	proxy, err := SelectBy(store, f, strategy)
+	if err != nil {
+		return "", err
+	}
+
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	if lease, ok := sessions[session]; ok {
+		lease.Proxy = proxy
+		lease.Filter = f
+		lease.failed = false
+		lease.Failovers++
+		logger.Info("会话 %s 绑定的代理 %s 已失效，切换到 %s", session, pinned, proxy)
+		publishSession(session, SessionFailover, proxy, pinned)
+		return proxy, nil
+	}
+	sweepSessions(now)
+	sessions[session] = &Lease{
+		Session:   session,
+		Proxy:     proxy,
+		Filter:    f,
+		CreatedAt: now,
+		ExpiresAt: now.Add(sessionTTL),
+	}
+	publishSession(session, SessionCreated, proxy, "")
+	return proxy, nil
+}
+
+// Sessions 返回当前有效的会话租约，按创建时间排序
+func Sessions() []Lease {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	sweepSessions(time.Now())
+
+	result := make([]Lease, 0, len(sessions))
+	for _, lease := range sessions {
+		result = append(result, *lease)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result
+}
+
+// GetSession 获取会话租约
+func GetSession(session string) (Lease, bool) {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	lease, ok := sessions[session]
+	if !ok || time.Now().After(lease.ExpiresAt) {
+		return Lease{}, false
+	}
+	return *lease, true
+}
+
+// RevokeSession 撤销会话租约，下次使用时重新选择代理
+func RevokeSession(session string) error {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	lease, ok := sessions[session]
+	if !ok {
+		return fmt.Errorf("会话不存在: %s", session)
+	}
+	delete(sessions, session)
+	publishSession(session, SessionRevoked, lease.Proxy, "")
+	return nil
+}
+
+// MarkSessionFailed 会话绑定的代理拨号失败，下次使用时切换到新的代理
+func MarkSessionFailed(session, proxy string) {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	if lease, ok := sessions[session]; ok && lease.Proxy == proxy {
+		lease.failed = true
+	}
+}
+
+// sweepSessions 清理已过期的租约，调用方需持有sessionMu
+func sweepSessions(now time.Time) {
+	for id, lease := range sessions {
+		if now.After(lease.ExpiresAt) {
+			delete(sessions, id)
+			publishSession(id, SessionExpired, lease.Proxy, "")
+		}
+	}
+}
+
+func publishSession(session, action, proxy, previous string) {
+	event.Publish(event.TypeSession, SessionEvent{
+		Session:  session,
+		Action:   action,
+		Proxy:    proxy,
+		Previous: previous,
+	})
+}
@@ -0,0 +1,136 @@
+package pool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/overflow0verture/proxy_harvester/internal/event"
+)
+
+func TestSelectStickyReusesLease(t *testing.T) {
+	store, _ := newTestStore(t, "sticky", 3)
+	first, err := SelectSticky(store, Filter{}, "reuse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if got, _ := SelectSticky(store, Filter{}, "reuse"); got != first {
+			t.Fatalf("第%d次选择 = %s, want %s", i+2, got, first)
+		}
+	}
+	lease, ok := GetSession("reuse")
+	if !ok || lease.Proxy != first || lease.Failovers != 0 {
+		t.Errorf("租约 = %+v (%v)", lease, ok)
+	}
+}
+
+func TestSelectStickyFailover(t *testing.T) {
+	tests := []struct {
+		name       string
+		invalidate func(store ProxyStore, session, proxy string)
+	}{
+		{"拨号失败", func(store ProxyStore, session, proxy string) { MarkSessionFailed(session, proxy) }},
+		{"被隔离", func(store ProxyStore, session, proxy string) {
+			metaMu.Lock()
+			getOrCreateMeta(proxy).QuarantineUntil = time.Now().Add(time.Minute)
+			metaMu.Unlock()
+		}},
+		{"被剔除", func(store ProxyStore, session, proxy string) { store.Remove(proxy) }},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, _ := newTestStore(t, "failover"+string(rune('a'+i)), 3)
+			session := "failover-" + tt.name
+			pinned, err := SelectSticky(store, Filter{}, session)
+			if err != nil {
+				t.Fatal(err)
+			}
+			events, cancel := event.Subscribe(16, event.TypeSession)
+			defer cancel()
+
+			tt.invalidate(store, session, pinned)
+			next, err := SelectSticky(store, Filter{}, session)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next == pinned {
+				t.Fatalf("绑定的代理失效后仍选择 %s", pinned)
+			}
+			lease, _ := GetSession(session)
+			if lease.Proxy != next || lease.Failovers != 1 {
+				t.Errorf("切换后租约 = %+v", lease)
+			}
+			if again, _ := SelectSticky(store, Filter{}, session); again != next {
+				t.Errorf("切换后未固定使用新代理: %s, want %s", again, next)
+			}
+
+			ev := waitSessionEvent(t, events, session)
+			if ev.Action != SessionFailover || ev.Proxy != next || ev.Previous != pinned {
+				t.Errorf("会话事件 = %+v", ev)
+			}
+		})
+	}
+}
+
+func TestSelectStickyExpiry(t *testing.T) {
+	store, _ := newTestStore(t, "expiry", 2)
+	SetSessionTTL(50 * time.Millisecond)
+	defer SetSessionTTL(0)
+
+	first, err := SelectSticky(store, Filter{}, "expiry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, cancel := event.Subscribe(16, event.TypeSession)
+	defer cancel()
+	time.Sleep(80 * time.Millisecond)
+
+	if _, ok := GetSession("expiry"); ok {
+		t.Fatal("租约到期后仍可查询")
+	}
+	if _, err := SelectSticky(store, Filter{}, "expiry"); err != nil {
+		t.Fatal(err)
+	}
+	if ev := waitSessionEvent(t, events, "expiry"); ev.Action != SessionExpired || ev.Proxy != first {
+		t.Errorf("到期事件 = %+v", ev)
+	}
+	if ev := waitSessionEvent(t, events, "expiry"); ev.Action != SessionCreated {
+		t.Errorf("到期后应新建租约，事件 = %+v", ev)
+	}
+	lease, ok := GetSession("expiry")
+	if !ok || lease.Failovers != 0 {
+		t.Errorf("新租约 = %+v (%v)", lease, ok)
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	store, _ := newTestStore(t, "revoke", 2)
+	if _, err := SelectSticky(store, Filter{}, "revoke"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RevokeSession("revoke"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := GetSession("revoke"); ok {
+		t.Error("撤销后租约仍存在")
+	}
+	if err := RevokeSession("revoke"); err == nil {
+		t.Error("撤销不存在的会话应返回错误")
+	}
+}
+
+// waitSessionEvent 等待指定会话的下一个事件
+func waitSessionEvent(t *testing.T, events <-chan event.Event, session string) SessionEvent {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case ev := <-events:
+			if se := ev.Data.(SessionEvent); se.Session == session {
+				return se
+			}
+		case <-timeout:
+			t.Fatalf("未收到会话 %s 的事件", session)
+		}
+	}
+}
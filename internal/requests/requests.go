@@ -28,11 +28,11 @@ func InitRequests(proxyStore pool.ProxyStore) {
 // Response HTTP响应结构
 type Response struct {
 	*http.Response
-	Text    string            // 响应文本内容
-	Content []byte            // 响应二进制内容
+	Text    string                    // 响应文本内容
+	Content []byte                    // 响应二进制内容
 	JSON    func(v interface{}) error // 解析JSON的便捷方法
-	URL     string            // 最终的URL（处理重定向后）
-	Headers map[string]string // 响应头
+	URL     string                    // 最终的URL（处理重定向后）
+	Headers map[string]string         // 响应头
 }
 
 // RequestOptions 请求选项
@@ -43,6 +43,8 @@ type RequestOptions struct {
 	Data    interface{}       // POST数据
 	JSON    interface{}       // JSON数据
 	Params  map[string]string // URL参数
+	// InsecureSkipVerify 是否跳过TLS证书校验，默认false（校验证书）
+	InsecureSkipVerify bool
 }
 
 // Get 发送GET请求
@@ -97,6 +99,7 @@ func request(method, reqURL string, options ...RequestOptions) (*Response, error
 			opts.Params = options[0].Params
 		}
 		opts.Proxies = options[0].Proxies
+		opts.InsecureSkipVerify = options[0].InsecureSkipVerify
 	}
 
 	// 处理URL参数
@@ -105,7 +108,7 @@ func request(method, reqURL string, options ...RequestOptions) (*Response, error
 	}
 
 	// 创建HTTP客户端
-	client := createHTTPClient(opts.Timeout, opts.Proxies)
+	client := createHTTPClient(opts.Timeout, opts.Proxies, opts.InsecureSkipVerify)
 
 	// 准备请求体
 	var body io.Reader
@@ -161,11 +164,12 @@ func request(method, reqURL string, options ...RequestOptions) (*Response, error
 }
 
 // createHTTPClient 创建HTTP客户端
-func createHTTPClient(timeout int, useProxies bool) *http.Client {
+// 默认校验TLS证书，代理篡改HTTPS时请求会失败，确需跳过校验时由调用方显式开启insecure
+func createHTTPClient(timeout int, useProxies bool, insecure bool) *http.Client {
 	client := &http.Client{
 		Timeout: time.Duration(timeout) * time.Second,
 		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure},
 		},
 	}
 
@@ -175,7 +179,7 @@ func createHTTPClient(timeout int, useProxies bool) *http.Client {
 			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
 				return netutil.TransmitReqFromClient(network, addr, globalProxyStore, timeout)
 			},
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure},
 		}
 	}
 
@@ -245,11 +249,12 @@ func newResponse(resp *http.Response) (*Response, error) {
 
 // Session HTTP会话，支持cookie持久化
 type Session struct {
-	client  *http.Client
-	headers map[string]string
-	cookies []*http.Cookie
-	timeout int
-	proxies bool
+	client   *http.Client
+	headers  map[string]string
+	cookies  []*http.Cookie
+	timeout  int
+	proxies  bool
+	insecure bool
 }
 
 // NewSession 创建新的HTTP会话
@@ -288,13 +293,19 @@ func (s *Session) SetProxies(enable bool) {
 	s.proxies = enable
 }
 
+// SetInsecureSkipVerify 设置是否跳过TLS证书校验
+func (s *Session) SetInsecureSkipVerify(insecure bool) {
+	s.insecure = insecure
+}
+
 // request Session的请求方法
 func (s *Session) request(method, url string, options ...RequestOptions) (*Response, error) {
 	// 合并会话和请求选项
 	opts := RequestOptions{
-		Headers: make(map[string]string),
-		Timeout: s.timeout,
-		Proxies: s.proxies,
+		Headers:            make(map[string]string),
+		Timeout:            s.timeout,
+		Proxies:            s.proxies,
+		InsecureSkipVerify: s.insecure,
 	}
 
 	// 复制会话头
@@ -321,7 +332,10 @@ func (s *Session) request(method, url string, options ...RequestOptions) (*Respo
 		if options[0].Params != nil {
 			opts.Params = options[0].Params
 		}
+		if options[0].InsecureSkipVerify {
+			opts.InsecureSkipVerify = true
+		}
 	}
 
 	return request(method, url, opts)
-} 
\ No newline at end of file
+}
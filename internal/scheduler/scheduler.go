@@ -1,17 +1,22 @@
 package scheduler
 
 import (
+	"context"
+	"errors"
+	"strings"
+
 	"github.com/overflow0verture/proxy_harvester/internal/check"
 	"github.com/overflow0verture/proxy_harvester/internal/config"
-	"github.com/overflow0verture/proxy_harvester/internal/pool"
 	"github.com/overflow0verture/proxy_harvester/internal/logger"
+	"github.com/overflow0verture/proxy_harvester/internal/pool"
 	"github.com/robfig/cron/v3"
-	"strings"
 )
 
+var cronJob *cron.Cron
+
 // Start 启动所有定时任务
 func Start(cfg config.Config, proxyStore pool.ProxyStore) {
-	cronJob := cron.New()
+	cronJob = cron.New()
 	cronFlag := false
 
 	if periodicChecking := strings.TrimSpace(cfg.Task.PeriodicChecking); periodicChecking != "" {
@@ -29,6 +34,19 @@ func Start(cfg config.Config, proxyStore pool.ProxyStore) {
 	}
 }
 
+// Stop 停止定时任务并等待正在执行的自检结束
+func Stop(ctx context.Context) error {
+	if cronJob == nil {
+		return nil
+	}
+	select {
+	case <-cronJob.Stop().Done():
+		return nil
+	case <-ctx.Done():
+		return errors.New("等待代理存活自检结束超时")
+	}
+}
+
 // 启动所有 Provider 的定时任务
 //func StartProvidersWithCron(providers []provider.ProxyProvider) {
 //	cronJob := cron.New()
@@ -0,0 +1,91 @@
+package socks5server
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/overflow0verture/proxy_harvester/internal/access"
+	"github.com/overflow0verture/proxy_harvester/internal/netutil"
+	"github.com/overflow0verture/proxy_harvester/internal/user"
+)
+
+// trackedConn 到目标的连接，统计上下行字节数并记录使用的上游代理
+type trackedConn struct {
+	net.Conn
+	upstream string
+	up       atomic.Int64 // 写入即客户端发往目标
+	down     atomic.Int64 // 读取即目标发往客户端
+}
+
+// Read 读取目标数据，计入下行
+func (c *trackedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.down.Add(int64(n))
+	return n, err
+}
+
+// Write 写入目标数据，计入上行
+func (c *trackedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.up.Add(int64(n))
+	return n, err
+}
+
+// CloseWrite 关闭写端，底层连接不支持时直接关闭
+func (c *trackedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+// traceDial 记录拨号实际使用的上游代理，返回的连接统计流量
+func traceDial(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		trace := &netutil.DialTrace{}
+		conn, err := dial(netutil.WithDialTrace(ctx, trace), network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &trackedConn{Conn: conn, upstream: trace.Proxy}, nil
+	}
+}
+
+// newAccessRecord 创建访问记录，用户优先取多用户认证的用户名
+func newAccessRecord(ctx context.Context, listener string, remote net.Addr, authUser, dest string) access.Record {
+	rec := access.Record{
+		Listener:    listener,
+		User:        authUser,
+		Destination: dest,
+		Start:       time.Now(),
+	}
+	if remote != nil {
+		rec.Client = remote.String()
+	}
+	if acct := user.FromContext(ctx); acct != nil {
+		rec.User = acct.Name()
+	}
+	return rec
+}
+
+// tunnelAccess 开始记录隧道连接，返回的函数在转发结束时调用
+func tunnelAccess(rec access.Record, target net.Conn) func(reason string, err error) {
+	tracked, _ := target.(*trackedConn)
+	if tracked != nil {
+		rec.Upstream = tracked.upstream
+	}
+	access.Begin(rec)
+	return func(reason string, err error) {
+		if tracked != nil {
+			rec.BytesUp = tracked.up.Load()
+			rec.BytesDown = tracked.down.Load()
+		}
+		rec.CloseReason = reason
+		if err != nil {
+			rec.Error = err.Error()
+		}
+		access.Finish(rec)
+	}
+}
@@ -0,0 +1,204 @@
+package socks5server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/overflow0verture/proxy_harvester/internal/config"
+	"github.com/overflow0verture/proxy_harvester/internal/logger"
+	"github.com/overflow0verture/proxy_harvester/internal/netutil"
+)
+
+// DestinationFilter 按目标地址过滤，用于UDP数据报等无法按请求校验的场景
+// ctx为关联建立时规则返回的ctx，携带认证用户等信息
+type DestinationFilter interface {
+	AllowDestination(ctx context.Context, addr string) bool
+}
+
+// aclRule 单条目标地址规则
+// 格式：主机[:端口]，主机可以是域名、通配符域名(*.example.com)、IP、CIDR或*，
+// 端口可以是单个端口或范围(80-443)，IPv6需加方括号，如 [fd00::]/8:443
+type aclRule struct {
+	raw     string
+	host    string
+	network *net.IPNet
+	portLo  int
+	portHi  int
+}
+
+// parseACLRule 解析目标地址规则
+func parseACLRule(raw string) (aclRule, error) {
+	r := aclRule{raw: raw, portLo: 0, portHi: 65535}
+	host, port := raw, ""
+	if strings.HasPrefix(raw, "[") {
+		end := strings.Index(raw, "]")
+		if end < 0 {
+			return r, fmt.Errorf("规则格式错误: %s", raw)
+		}
+		host = raw[1:end]
+		rest := raw[end+1:]
+		// [fd00::]/8:443 形式的CIDR
+		if strings.HasPrefix(rest, "/") {
+			bits, p, _ := strings.Cut(rest[1:], ":")
+			host += "/" + bits
+			port = p
+		} else {
+			port = strings.TrimPrefix(rest, ":")
+		}
+	} else if i := strings.LastIndex(raw, ":"); i >= 0 {
+		host, port = raw[:i], raw[i+1:]
+	}
+
+	if host == "" {
+		host = "*"
+	}
+	if strings.Contains(host, "/") {
+		_, network, err := net.ParseCIDR(host)
+		if err != nil {
+			return r, fmt.Errorf("CIDR格式错误: %s", raw)
+		}
+		r.network = network
+	} else {
+		r.host = strings.ToLower(host)
+	}
+
+	if port != "" {
+		lo, hi, isRange := strings.Cut(port, "-")
+		var err error
+		if r.portLo, err = strconv.Atoi(lo); err != nil {
+			return r, fmt.Errorf("端口格式错误: %s", raw)
+		}
+		r.portHi = r.portLo
+		if isRange {
+			if r.portHi, err = strconv.Atoi(hi); err != nil || r.portHi < r.portLo {
+				return r, fmt.Errorf("端口范围错误: %s", raw)
+			}
+		}
+	}
+	return r, nil
+}
+
+// match 判断目标是否命中规则，ip为目标是IP地址时的解析结果
+func (r aclRule) match(host string, ip net.IP, port int) bool {
+	if port < r.portLo || port > r.portHi {
+		return false
+	}
+	if r.network != nil {
+		return ip != nil && r.network.Contains(ip)
+	}
+	if r.host == "*" {
+		return true
+	}
+	if ip != nil {
+		if ruleIP := net.ParseIP(r.host); ruleIP != nil {
+			return ruleIP.Equal(ip)
+		}
+	}
+	if ok, _ := path.Match(r.host, host); ok {
+		return true
+	}
+	// *.example.com 同时匹配 example.com
+	return strings.HasPrefix(r.host, "*.") && host == r.host[2:]
+}
+
+// DestinationACL 本地监听的目标地址访问控制
+// 先匹配拒绝规则，再默认拒绝内网地址（允许规则显式放行的除外），配置了允许规则时只放行命中的目标
+// 域名由上游代理解析，只能按域名匹配，内网地址拦截只对IP和localhost生效
+//
+// 作为RuleSet放在各监听ruleChain的第一位，在拨号上游之前执行：
+// SOCKS5和透明代理在读出目标地址后调用Allow，HTTP代理在CONNECT和转发请求前调用Allow，
+// SOCKS5 UDP ASSOCIATE经ruleChain的DestinationFilter按每个数据报的目标调用AllowDestination
+type DestinationACL struct {
+	allow        []aclRule
+	deny         []aclRule
+	allowPrivate bool
+}
+
+// NewDestinationACL 按配置创建访问控制
+func NewDestinationACL(cfg config.ACLConfig) (*DestinationACL, error) {
+	acl := &DestinationACL{allowPrivate: cfg.AllowPrivate}
+	for _, raw := range cfg.Allow {
+		r, err := parseACLRule(raw)
+		if err != nil {
+			return nil, err
+		}
+		acl.allow = append(acl.allow, r)
+	}
+	for _, raw := range cfg.Deny {
+		r, err := parseACLRule(raw)
+		if err != nil {
+			return nil, err
+		}
+		acl.deny = append(acl.deny, r)
+	}
+	return acl, nil
+}
+
+// check 校验目标地址，拒绝时返回原因
+func (acl *DestinationACL) check(addr string) (bool, string) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false, "目标地址格式错误"
+	}
+	port, _ := strconv.Atoi(portStr)
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	ip := net.ParseIP(host)
+
+	for _, r := range acl.deny {
+		if r.match(host, ip, port) {
+			return false, "命中拒绝规则 " + r.raw
+		}
+	}
+
+	allowed := false
+	for _, r := range acl.allow {
+		if r.match(host, ip, port) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed && !acl.allowPrivate && isPrivateHost(host, ip) {
+		return false, "内网地址"
+	}
+	if len(acl.allow) > 0 && !allowed {
+		return false, "未命中允许规则"
+	}
+	return true, ""
+}
+
+// sharedAddressSpace 运营商级NAT地址 100.64.0.0/10（RFC 6598），net.IP.IsPrivate不包含
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// isPrivateHost 是否为内网、运营商级NAT、链路本地或回环地址
+func isPrivateHost(host string, ip net.IP) bool {
+	if ip == nil {
+		return host == "localhost" || strings.HasSuffix(host, ".localhost")
+	}
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip)
+}
+
+// AllowDestination 校验目标地址，拒绝时记录日志
+func (acl *DestinationACL) AllowDestination(_ context.Context, addr string) bool {
+	ok, reason := acl.check(addr)
+	if !ok {
+		logger.Info("拒绝访问目标 %s: %s", addr, reason)
+	}
+	return ok
+}
+
+// Allow 校验CONNECT请求的目标地址，UDP ASSOCIATE按数据报校验
+func (acl *DestinationACL) Allow(ctx context.Context, req *Request) (context.Context, bool) {
+	if req.Command != netutil.Socks5CmdConnect {
+		return ctx, true
+	}
+	ok, reason := acl.check(req.DestAddr)
+	if !ok {
+		logger.Info("拒绝 %s 访问目标 %s: %s", req.RemoteAddr, req.DestAddr, reason)
+	}
+	return ctx, ok
+}
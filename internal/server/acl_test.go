@@ -0,0 +1,127 @@
+package socks5server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/overflow0verture/proxy_harvester/internal/config"
+)
+
+func TestParseACLRule(t *testing.T) {
+	tests := []struct {
+		raw     string
+		host    string
+		cidr    string
+		lo, hi  int
+		wantErr bool
+	}{
+		{raw: "*", host: "*", lo: 0, hi: 65535},
+		{raw: "*:25", host: "*", lo: 25, hi: 25},
+		{raw: ":443", host: "*", lo: 443, hi: 443},
+		{raw: "*.Example.com:1-1023", host: "*.example.com", lo: 1, hi: 1023},
+		{raw: "10.0.0.0/8", cidr: "10.0.0.0/8", lo: 0, hi: 65535},
+		{raw: "10.0.0.0/8:22", cidr: "10.0.0.0/8", lo: 22, hi: 22},
+		{raw: "[::1]", host: "::1", lo: 0, hi: 65535},
+		{raw: "[::1]:8080", host: "::1", lo: 8080, hi: 8080},
+		{raw: "[fd00::]/8:443", cidr: "fd00::/8", lo: 443, hi: 443},
+		{raw: "[fd00::]/8", cidr: "fd00::/8", lo: 0, hi: 65535},
+		{raw: "[::1", wantErr: true},
+		{raw: "10.0.0.0/33", wantErr: true},
+		{raw: "example.com:http", wantErr: true},
+		{raw: "example.com:443-80", wantErr: true},
+		{raw: "example.com:80-x", wantErr: true},
+	}
+	for _, tt := range tests {
+		r, err := parseACLRule(tt.raw)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseACLRule(%q) 应返回错误", tt.raw)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseACLRule(%q): %v", tt.raw, err)
+			continue
+		}
+		if r.portLo != tt.lo || r.portHi != tt.hi {
+			t.Errorf("parseACLRule(%q) 端口 = %d-%d, want %d-%d", tt.raw, r.portLo, r.portHi, tt.lo, tt.hi)
+		}
+		if tt.cidr != "" {
+			if r.network == nil || r.network.String() != tt.cidr {
+				t.Errorf("parseACLRule(%q) CIDR = %v, want %s", tt.raw, r.network, tt.cidr)
+			}
+		} else if r.host != tt.host {
+			t.Errorf("parseACLRule(%q) 主机 = %q, want %q", tt.raw, r.host, tt.host)
+		}
+	}
+}
+
+func TestDestinationACLCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.ACLConfig
+		addr string
+		want bool
+	}{
+		{"默认放行公网", config.ACLConfig{}, "example.com:443", true},
+		{"默认拒绝内网", config.ACLConfig{}, "192.168.1.1:80", false},
+		{"默认拒绝运营商级NAT", config.ACLConfig{}, "100.64.1.1:80", false},
+		{"默认拒绝localhost", config.ACLConfig{}, "localhost:8080", false},
+		{"默认拒绝IPv6回环", config.ACLConfig{}, "[::1]:80", false},
+		{"允许内网", config.ACLConfig{AllowPrivate: true}, "10.1.2.3:22", true},
+		{"格式错误", config.ACLConfig{}, "example.com", false},
+		{"拒绝端口", config.ACLConfig{Deny: []string{"*:25"}}, "mail.example.com:25", false},
+		{"拒绝端口不影响其他端口", config.ACLConfig{Deny: []string{"*:25"}}, "mail.example.com:587", true},
+		{"通配符匹配子域名", config.ACLConfig{Deny: []string{"*.example.com"}}, "a.b.example.com:443", false},
+		{"通配符匹配根域名", config.ACLConfig{Deny: []string{"*.example.com"}}, "example.com:443", false},
+		{"域名末尾的点", config.ACLConfig{Deny: []string{"example.com"}}, "example.com.:443", false},
+		{"CIDR带端口", config.ACLConfig{Deny: []string{"203.0.113.0/24:443"}}, "203.0.113.7:443", false},
+		{"CIDR带端口不匹配其他端口", config.ACLConfig{Deny: []string{"203.0.113.0/24:443"}}, "203.0.113.7:80", true},
+		{"IPv6 CIDR带端口", config.ACLConfig{Deny: []string{"[2001:db8::]/32:443"}}, "[2001:db8::1]:443", false},
+		{"允许规则显式放行内网", config.ACLConfig{Allow: []string{"10.0.0.0/8"}}, "10.0.0.1:80", true},
+		{"允许规则未命中", config.ACLConfig{Allow: []string{"*.example.com:443"}}, "example.org:443", false},
+		{"拒绝优先于允许", config.ACLConfig{Allow: []string{"*"}, Deny: []string{"1.2.3.4"}}, "1.2.3.4:80", false},
+		{"IP规则", config.ACLConfig{Deny: []string{"[2001:db8::1]"}}, "[2001:db8:0::1]:443", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acl, err := NewDestinationACL(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, reason := acl.check(tt.addr); got != tt.want {
+				t.Errorf("check(%q) = %v (%s), want %v", tt.addr, got, reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsPrivateHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"10.0.0.1", true},
+		{"172.16.0.1", true},
+		{"192.168.0.1", true},
+		{"100.64.0.1", true},
+		{"100.127.255.255", true},
+		{"100.128.0.1", false},
+		{"127.0.0.1", true},
+		{"169.254.1.1", true},
+		{"0.0.0.0", true},
+		{"::1", true},
+		{"fd00::1", true},
+		{"fe80::1", true},
+		{"8.8.8.8", false},
+		{"2001:4860:4860::8888", false},
+		{"localhost", true},
+		{"app.localhost", true},
+		{"example.com", false},
+	}
+	for _, tt := range tests {
+		if got := isPrivateHost(tt.host, net.ParseIP(tt.host)); got != tt.want {
+			t.Errorf("isPrivateHost(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
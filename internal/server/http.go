@@ -0,0 +1,426 @@
+package socks5server
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/overflow0verture/proxy_harvester/internal/access"
+	"github.com/overflow0verture/proxy_harvester/internal/config"
+	"github.com/overflow0verture/proxy_harvester/internal/errclass"
+	"github.com/overflow0verture/proxy_harvester/internal/lifecycle"
+	"github.com/overflow0verture/proxy_harvester/internal/logger"
+	"github.com/overflow0verture/proxy_harvester/internal/netutil"
+	"github.com/overflow0verture/proxy_harvester/internal/pool"
+	"github.com/overflow0verture/proxy_harvester/internal/user"
+)
+
+// SessionHeader 指定粘性会话ID的请求头，同一会话固定使用同一个上游代理
+const SessionHeader = "X-Proxy-Session"
+
+// 逐跳请求头，转发时需要移除
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// HTTPProxy HTTP正向代理，支持CONNECT隧道和绝对URI转发
+type HTTPProxy struct {
+	name        string // 监听名称，用于访问记录
+	perRequest  bool   // 普通HTTP请求每个请求重新选择上游，不复用到上游的连接
+	credentials CredentialStore
+	rules       RuleSet
+	dial        func(ctx context.Context, network, addr string) (net.Conn, error)
+	transport   *http.Transport
+	// oneshot 不复用连接的共享Transport，上游由请求ctx中的路由和用户决定
+	oneshot *http.Transport
+
+	// CONNECT隧道接管了连接，http.Server关闭时不会等待，需要单独记录
+	mu      sync.Mutex
+	tunnels map[net.Conn]net.Conn // 客户端连接 -> 上游连接
+	active  sync.WaitGroup
+	closed  bool
+}
+
+// NewHTTPProxy 创建HTTP正向代理，credentials为nil时不要求认证，rules为nil时放行所有请求
+func NewHTTPProxy(credentials CredentialStore, rules RuleSet, dial func(ctx context.Context, network, addr string) (net.Conn, error)) *HTTPProxy {
+	return &HTTPProxy{
+		name:        access.ListenerHTTP,
+		credentials: credentials,
+		rules:       rules,
+		dial:        dial,
+		transport: &http.Transport{
+			DialContext:         dial,
+			MaxIdleConnsPerHost: 10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		oneshot: &http.Transport{
+			DialContext:       dial,
+			DisableKeepAlives: true,
+		},
+		tunnels: make(map[net.Conn]net.Conn),
+	}
+}
+
+// ServeHTTP 处理代理请求
+func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	authUser, ok := p.authenticate(r)
+	if !ok {
+		w.Header().Set("Proxy-Authenticate", `Basic realm="proxy_harvester"`)
+		http.Error(w, "Proxy Authentication Required", http.StatusProxyAuthRequired)
+		return
+	}
+
+	// 会话ID只用于选路，不转发给目标
+	if session := r.Header.Get(SessionHeader); session != "" {
+		r.Header.Del(SessionHeader)
+		route := netutil.RouteFromContext(r.Context())
+		route.Session = session
+		r = r.WithContext(netutil.WithRoute(r.Context(), route))
+	}
+
+	if r.Method != http.MethodConnect && !r.URL.IsAbs() {
+		http.Error(w, "只支持代理请求（绝对URI）", http.StatusBadRequest)
+		return
+	}
+
+	if p.rules != nil {
+		req := &Request{
+			Command:    netutil.Socks5CmdConnect,
+			DestAddr:   targetAddr(r),
+			RemoteAddr: remoteAddr(r),
+			AuthUser:   authUser,
+		}
+		ctx, ok := p.rules.Allow(r.Context(), req)
+		if !ok {
+			http.Error(w, "请求被拒绝", http.StatusForbidden)
+			return
+		}
+		r = r.WithContext(ctx)
+	}
+
+	if r.Method == http.MethodConnect {
+		p.handleConnect(w, r, authUser)
+		return
+	}
+	p.handleForward(w, r, authUser)
+}
+
+// targetAddr 请求的目标地址 host:port
+func targetAddr(r *http.Request) string {
+	host := r.Host
+	if r.Method != http.MethodConnect {
+		host = r.URL.Host
+	}
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	if r.Method == http.MethodConnect || r.URL.Scheme == "https" {
+		return net.JoinHostPort(host, "443")
+	}
+	return net.JoinHostPort(host, "80")
+}
+
+// authenticate 校验Proxy-Authorization中的Basic认证，返回用户名
+func (p *HTTPProxy) authenticate(r *http.Request) (string, bool) {
+	if p.credentials == nil {
+		return "", true
+	}
+	auth := r.Header.Get("Proxy-Authorization")
+	if !strings.HasPrefix(auth, "Basic ") {
+		return "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(auth, "Basic "))
+	if err != nil {
+		return "", false
+	}
+	name, pass, ok := strings.Cut(string(decoded), ":")
+	if !ok || !p.credentials.Valid(name, pass) {
+		return "", false
+	}
+	return name, true
+}
+
+// handleConnect 建立CONNECT隧道
+func (p *HTTPProxy) handleConnect(w http.ResponseWriter, r *http.Request, authUser string) {
+	target := targetAddr(r)
+	rec := newAccessRecord(r.Context(), p.name, remoteAddr(r), authUser, target)
+
+	upstream, err := p.dial(r.Context(), "tcp", target)
+	if err != nil {
+		access.Failed(rec, err)
+		logger.Debug("HTTP代理CONNECT %s 失败: %v", target, err)
+		http.Error(w, "连接目标失败", dialErrorStatus(err))
+		return
+	}
+	defer upstream.Close()
+	finish := tunnelAccess(rec, upstream)
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		finish(access.CloseError, errors.New("不支持CONNECT"))
+		http.Error(w, "不支持CONNECT", http.StatusInternalServerError)
+		return
+	}
+	client, rw, err := hijacker.Hijack()
+	if err != nil {
+		finish(access.CloseError, err)
+		return
+	}
+	defer client.Close()
+	if !p.trackTunnel(client, upstream) {
+		finish(access.CloseError, ErrServerClosed)
+		return
+	}
+	defer p.untrackTunnel(client)
+
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		finish(access.CloseError, err)
+		return
+	}
+	// 客户端可能在收到应答前就发送了数据，先转发已缓冲的部分
+	if n := rw.Reader.Buffered(); n > 0 {
+		buffered, _ := rw.Reader.Peek(n)
+		if _, err := upstream.Write(buffered); err != nil {
+			finish(access.CloseError, err)
+			return
+		}
+	}
+
+	finish(relay(client, upstream))
+}
+
+// trackTunnel 记录CONNECT隧道，服务已关闭时返回false
+func (p *HTTPProxy) trackTunnel(client, upstream net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+	p.tunnels[client] = upstream
+	p.active.Add(1)
+	return true
+}
+
+func (p *HTTPProxy) untrackTunnel(client net.Conn) {
+	p.mu.Lock()
+	delete(p.tunnels, client)
+	p.mu.Unlock()
+	p.active.Done()
+}
+
+// Shutdown 不再接受新的CONNECT隧道并等待已有隧道结束，ctx到期时强制关闭剩余隧道
+func (p *HTTPProxy) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.active.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		p.transport.CloseIdleConnections()
+		return nil
+	case <-ctx.Done():
+	}
+
+	p.mu.Lock()
+	remaining := len(p.tunnels)
+	for client, upstream := range p.tunnels {
+		client.Close()
+		upstream.Close()
+	}
+	p.mu.Unlock()
+	<-done
+	p.transport.CloseIdleConnections()
+	return fmt.Errorf("等待隧道结束超时，强制关闭 %d 个隧道", remaining)
+}
+
+// remoteAddr 请求的客户端地址
+func remoteAddr(r *http.Request) net.Addr {
+	remote, _ := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if remote == nil {
+		return nil
+	}
+	return remote
+}
+
+// handleForward 转发绝对URI形式的普通HTTP请求
+// 访问记录按请求统计，连接可能被多个请求复用，字节数只计请求体和响应体
+func (p *HTTPProxy) handleForward(w http.ResponseWriter, r *http.Request, authUser string) {
+	rec := newAccessRecord(r.Context(), p.name, remoteAddr(r), authUser, targetAddr(r))
+	var upstream *trackedConn
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			upstream, _ = info.Conn.(*trackedConn)
+		},
+	}
+	outReq := r.Clone(httptrace.WithClientTrace(r.Context(), trace))
+	outReq.RequestURI = ""
+	removeHopHeaders(outReq.Header)
+	body := &countingReader{r: outReq.Body}
+	if outReq.Body != nil && outReq.Body != http.NoBody {
+		outReq.Body = body
+	}
+
+	// 带会话或用户的请求不复用连接池中的连接，避免走到其他会话的上游或计入其他用户
+	// 按请求轮换时同样每个请求重新拨号，客户端连接上的后续请求会经不同的上游转发
+	transport := p.transport
+	if p.perRequest || netutil.RouteFromContext(r.Context()).Session != "" || user.FromContext(r.Context()) != nil {
+		transport = p.oneshot
+	}
+	resp, err := transport.RoundTrip(outReq)
+	if upstream != nil {
+		rec.Upstream = upstream.upstream
+	}
+	if err != nil {
+		access.Failed(rec, err)
+		logger.Debug("HTTP代理转发 %s 失败: %v", r.URL, err)
+		http.Error(w, "转发请求失败", dialErrorStatus(err))
+		return
+	}
+	defer resp.Body.Close()
+	access.Begin(rec)
+
+	removeHopHeaders(resp.Header)
+	for key, values := range resp.Header {
+		for _, v := range values {
+			w.Header().Add(key, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	n, err := io.Copy(w, resp.Body)
+	rec.BytesUp = body.n
+	rec.BytesDown = n
+	rec.CloseReason = access.CloseCompleted
+	if err != nil {
+		rec.CloseReason, rec.Error = access.CloseError, err.Error()
+	}
+	access.Finish(rec)
+}
+
+// countingReader 统计请求体字节数
+type countingReader struct {
+	r io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) Close() error {
+	return c.r.Close()
+}
+
+// dialErrorStatus 连接上游失败时返回的状态码
+func dialErrorStatus(err error) int {
+	if errors.Is(err, user.ErrQuotaExceeded) {
+		return http.StatusTooManyRequests
+	}
+	if errors.Is(err, netutil.ErrNoProxy) {
+		return http.StatusServiceUnavailable
+	}
+	if errclass.Classify(err) == errclass.ConnectTimeout {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// removeHopHeaders 移除逐跳请求头，包括Connection中声明的头
+func removeHopHeaders(header http.Header) {
+	for _, v := range header.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			header.Del(strings.TrimSpace(name))
+		}
+	}
+	for _, h := range hopHeaders {
+		header.Del(h)
+	}
+}
+
+// StartHTTPServer 启动HTTP正向代理监听服务
+func StartHTTPServer(proxyStore pool.ProxyStore, cfg config.ListenerEntry, aclCfg config.ACLConfig, timeout int) {
+	logger.Info("HTTP代理服务 %s 启动中，监听地址: %s:%d", cfg.Name, cfg.IP, cfg.Port)
+
+	acl, err := NewDestinationACL(aclCfg)
+	if err != nil {
+		logger.Error("HTTP代理服务访问控制规则错误: %v", err)
+		return
+	}
+	rules := ruleChain{acl, listenerRules{entry: cfg}}
+
+	var credentials CredentialStore
+	if user.Enabled() {
+		credentials = userCredentials{}
+		rules = append(rules, userRules{})
+		logger.Info("HTTP代理服务已启用多用户认证")
+	} else if cfg.UserName != "" && cfg.Password != "" {
+		credentials = StaticCredentials{
+			cfg.UserName: cfg.Password,
+		}
+		logger.Info("HTTP代理服务已启用认证，用户名: %s", cfg.UserName)
+	}
+
+	proxy := NewHTTPProxy(credentials, rules, traceDial(accountDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return netutil.TransmitReqWithContext(ctx, network, addr, proxyStore, timeout)
+	})))
+	proxy.name = cfg.Name
+	if cfg.Rotation == config.RotationRequest {
+		proxy.perRequest = true
+		logger.Info("HTTP代理服务 %s 已启用按请求轮换，可通过请求头 %s 指定会话固定上游", cfg.Name, SessionHeader)
+	}
+
+	server := &http.Server{
+		Handler:           proxy,
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	ln, err := net.Listen("tcp", cfg.IP+":"+strconv.Itoa(cfg.Port))
+	if err != nil {
+		logger.Error("HTTP代理服务 %s 启动失败: %v", cfg.Name, err)
+		return
+	}
+
+	// 先停止监听，再等待进行中的请求和隧道结束
+	lifecycle.OnShutdownFunc(lifecycle.PhaseListeners, "HTTP代理监听 "+cfg.Name, func() error {
+		server.SetKeepAlivesEnabled(false)
+		return ln.Close()
+	})
+	lifecycle.OnShutdown(lifecycle.PhaseDrain, "HTTP代理连接 "+cfg.Name, func(ctx context.Context) error {
+		tunnelErr := make(chan error, 1)
+		go func() {
+			tunnelErr <- proxy.Shutdown(ctx)
+		}()
+		if err := server.Shutdown(ctx); err != nil {
+			server.Close()
+		}
+		return <-tunnelErr
+	})
+
+	if err := server.Serve(ln); err != nil && !errors.Is(err, net.ErrClosed) && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("HTTP代理服务 %s 异常退出: %v", cfg.Name, err)
+		return
+	}
+	logger.Info("HTTP代理服务 %s 已停止监听", cfg.Name)
+}
@@ -0,0 +1,102 @@
+package socks5server
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/overflow0verture/proxy_harvester/internal/config"
+	"github.com/overflow0verture/proxy_harvester/internal/logger"
+	"github.com/overflow0verture/proxy_harvester/internal/netutil"
+	"github.com/overflow0verture/proxy_harvester/internal/pool"
+)
+
+// defaultRotateInterval interval轮换未配置间隔时的默认值
+const defaultRotateInterval = 60 * time.Second
+
+// StartListener 按监听的协议启动SOCKS5、HTTP或透明代理监听
+func StartListener(proxyStore pool.ProxyStore, entry config.ListenerEntry, aclCfg config.ACLConfig, timeout int) {
+	if !pool.ValidStrategy(entry.Strategy) {
+		logger.Error("监听 %s 的选择策略 %s 不支持", entry.Name, entry.Strategy)
+		return
+	}
+	switch entry.Protocol {
+	case config.ProtocolSocks5:
+		StartServer(proxyStore, entry, aclCfg, timeout)
+	case config.ProtocolHTTP:
+		StartHTTPServer(proxyStore, entry, aclCfg, timeout)
+	case config.ProtocolTransparent:
+		StartTransparentServer(proxyStore, entry, aclCfg, timeout)
+	default:
+		logger.Error("监听 %s 的协议 %s 不支持", entry.Name, entry.Protocol)
+	}
+}
+
+// listenerRules 将监听配置的选择策略、筛选条件、竞速拨号数和轮换方式放入ctx
+// 只填充尚未由用户名选路参数或请求头指定的字段
+type listenerRules struct {
+	entry config.ListenerEntry
+}
+
+// Allow 合并监听的选路参数并放行
+func (r listenerRules) Allow(ctx context.Context, req *Request) (context.Context, bool) {
+	route := netutil.RouteFromContext(ctx)
+	if route.Filter.Country == "" {
+		route.Filter.Country = r.entry.Country
+	}
+	if route.Filter.Protocol == "" {
+		route.Filter.Protocol = r.entry.Proto
+	}
+	if route.Filter.Label == "" && len(route.Filter.Labels) == 0 {
+		route.Filter.Labels = r.entry.Labels
+	}
+	if route.Filter.Profile == "" {
+		route.Filter.Profile = r.entry.Profile
+	}
+	if route.Strategy == "" {
+		route.Strategy = r.entry.Strategy
+	}
+	if route.Race == 0 && r.entry.RaceDials > 1 {
+		route.Race = r.entry.RaceDials
+	}
+	if route.Session == "" {
+		route.Session = r.session(req)
+	}
+	return netutil.WithRoute(ctx, route), true
+}
+
+// session 按监听的策略和轮换方式生成会话ID，不需要会话时返回空
+// sticky按客户端IP固定上游；interval轮换时会话ID带上当前时间段，到下一个时间段自动更换上游
+func (r listenerRules) session(req *Request) string {
+	sticky := r.entry.Strategy == pool.StrategySticky
+	interval := r.entry.Rotation == config.RotationInterval
+	if !sticky && !interval {
+		return ""
+	}
+
+	session := r.entry.Name
+	if sticky {
+		session += "|" + clientHost(req.RemoteAddr)
+	}
+	if interval {
+		every := time.Duration(r.entry.RotateInterval) * time.Second
+		if every <= 0 {
+			every = defaultRotateInterval
+		}
+		session += "@" + strconv.FormatInt(time.Now().UnixNano()/int64(every), 10)
+	}
+	return session
+}
+
+// clientHost 客户端地址中的IP部分
+func clientHost(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,101 @@
+package socks5server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/overflow0verture/proxy_harvester/internal/netutil"
+)
+
+// 用户名中可携带的选路参数，格式与常见住宅代理服务商一致：
+// user-country-CN-session-abc123-proto-socks5-label-fofa
+const (
+	routeKeyCountry = "country"
+	routeKeySession = "session"
+	routeKeyProto   = "proto"
+	routeKeyLabel   = "label"
+)
+
+// ParseRoutingUser 解析用户名中的选路参数，返回基础用户名和选路参数
+// 基础用户名本身不能包含'-'
+func ParseRoutingUser(username string) (string, netutil.Route, error) {
+	var route netutil.Route
+	parts := strings.Split(username, "-")
+	base := parts[0]
+	if (len(parts)-1)%2 != 0 {
+		return "", route, fmt.Errorf("选路参数格式错误: %s", username)
+	}
+
+	for i := 1; i+1 < len(parts); i += 2 {
+		key, value := strings.ToLower(parts[i]), parts[i+1]
+		if value == "" {
+			return "", route, fmt.Errorf("选路参数 %s 的值为空", key)
+		}
+		switch key {
+		case routeKeyCountry:
+			route.Filter.Country = value
+		case routeKeySession:
+			route.Session = value
+		case routeKeyProto:
+			proto := strings.ToLower(value)
+			switch proto {
+			case "socks5", "socks4", "socks4a", "http", "https":
+			default:
+				return "", route, fmt.Errorf("不支持的协议: %s", value)
+			}
+			route.Filter.Protocol = proto
+		case routeKeyLabel:
+			route.Filter.Label = value
+		default:
+			return "", route, fmt.Errorf("未知的选路参数: %s", key)
+		}
+	}
+	return base, route, nil
+}
+
+// routingCredentials 解析用户名中的选路参数后，用基础用户名校验密码
+// base为nil时不校验密码，仅用于传递选路参数
+type routingCredentials struct {
+	base CredentialStore
+}
+
+// Valid 校验用户名密码
+func (c routingCredentials) Valid(user, password string) bool {
+	base, _, err := ParseRoutingUser(user)
+	if err != nil {
+		return false
+	}
+	if c.base == nil {
+		return true
+	}
+	return c.base.Valid(base, password)
+}
+
+// routingRules 将认证用户名中的选路参数放入ctx，供Dial选择上游代理
+type routingRules struct{}
+
+// Allow 解析选路参数并放行，用户名中指定的参数覆盖ctx中已有的选路参数
+func (routingRules) Allow(ctx context.Context, req *Request) (context.Context, bool) {
+	if req.AuthUser == "" {
+		return ctx, true
+	}
+	_, parsed, err := ParseRoutingUser(req.AuthUser)
+	if err != nil {
+		return ctx, false
+	}
+	route := netutil.RouteFromContext(ctx)
+	if parsed.Filter.Country != "" {
+		route.Filter.Country = parsed.Filter.Country
+	}
+	if parsed.Filter.Protocol != "" {
+		route.Filter.Protocol = parsed.Filter.Protocol
+	}
+	if parsed.Filter.Label != "" {
+		route.Filter.Label = parsed.Filter.Label
+	}
+	if parsed.Session != "" {
+		route.Session = parsed.Session
+	}
+	return netutil.WithRoute(ctx, route), true
+}
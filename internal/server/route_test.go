@@ -0,0 +1,53 @@
+package socks5server
+
+import (
+	"testing"
+
+	"github.com/overflow0verture/proxy_harvester/internal/netutil"
+	"github.com/overflow0verture/proxy_harvester/internal/pool"
+)
+
+func TestParseRoutingUser(t *testing.T) {
+	tests := []struct {
+		username string
+		base     string
+		route    netutil.Route
+		wantErr  bool
+	}{
+		{username: "alice", base: "alice"},
+		{username: "", base: ""},
+		{
+			username: "alice-country-中国-session-abc123-proto-SOCKS5-label-fofa",
+			base:     "alice",
+			route: netutil.Route{
+				Session: "abc123",
+				Filter:  pool.Filter{Country: "中国", Protocol: "socks5", Label: "fofa"},
+			},
+		},
+		{username: "alice-Session-s1", base: "alice", route: netutil.Route{Session: "s1"}},
+		{username: "alice-proto-https", base: "alice", route: netutil.Route{Filter: pool.Filter{Protocol: "https"}}},
+		{username: "alice-proto-socks4a", base: "alice", route: netutil.Route{Filter: pool.Filter{Protocol: "socks4a"}}},
+		{username: "alice-session-s1-session-s2", base: "alice", route: netutil.Route{Session: "s2"}},
+		{username: "alice-country", wantErr: true},
+		{username: "alice-country-", wantErr: true},
+		{username: "alice-proto-ftp", wantErr: true},
+		{username: "alice-color-red", wantErr: true},
+		{username: "alice-session-a-b", wantErr: true},
+	}
+	for _, tt := range tests {
+		base, route, err := ParseRoutingUser(tt.username)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseRoutingUser(%q) 应返回错误", tt.username)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseRoutingUser(%q): %v", tt.username, err)
+			continue
+		}
+		if base != tt.base || route.Session != tt.route.Session || route.Filter.Key() != tt.route.Filter.Key() {
+			t.Errorf("ParseRoutingUser(%q) = %q, %+v, want %q, %+v", tt.username, base, route, tt.base, tt.route)
+		}
+	}
+}
@@ -1,20 +1,21 @@
 package socks5server
 
 import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+
 	"github.com/overflow0verture/proxy_harvester/internal/config"
+	"github.com/overflow0verture/proxy_harvester/internal/lifecycle"
 	"github.com/overflow0verture/proxy_harvester/internal/logger"
 	"github.com/overflow0verture/proxy_harvester/internal/netutil"
 	"github.com/overflow0verture/proxy_harvester/internal/pool"
-	"context"
-	"github.com/armon/go-socks5"
-	"io"
-	"log"
-	"net"
-	"strconv"
+	"github.com/overflow0verture/proxy_harvester/internal/user"
 )
 
 // StartServer 启动socks5监听服务（增强版，提供更多日志）
-func StartServer(proxyStore pool.ProxyStore, cfg config.ListenerConfig, timeout int) {
+func StartServer(proxyStore pool.ProxyStore, cfg config.ListenerEntry, aclCfg config.ACLConfig, timeout int) {
 	// 获取代理池信息
 	proxyCount, _ := proxyStore.Len()
 	storeType := "未知"
@@ -24,37 +25,97 @@ func StartServer(proxyStore pool.ProxyStore, cfg config.ListenerConfig, timeout
 	case *pool.RedisProxyStore:
 		storeType = "Redis存储"
 	}
-	
-	logger.Info("Socks5服务启动中，监听地址: %s:%d，使用%s代理池，当前有 %d 个代理", 
-		cfg.IP, cfg.Port, storeType, proxyCount)
-	
-	conf := &socks5.Config{
-		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			return netutil.TransmitReqFromClient(network, addr, proxyStore, timeout)
-		},
-		Logger: log.New(io.Discard, "", log.LstdFlags),
-	}
-	
-	// 如果配置了用户名和密码，添加认证
+
+	logger.Info("Socks5服务 %s 启动中，监听地址: %s:%d，使用%s代理池，当前有 %d 个代理",
+		cfg.Name, cfg.IP, cfg.Port, storeType, proxyCount)
+
+	conf := &Socks5Config{
+		Name: cfg.Name,
+		Dial: traceDial(accountDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return netutil.TransmitReqWithContext(ctx, network, addr, proxyStore, timeout)
+		})),
+	}
+
+	// 开启UDP ASSOCIATE，经支持UDP的socks5上游转发
+	if cfg.UDP {
+		conf.DialUDP = func(ctx context.Context) (*netutil.UDPAssociation, error) {
+			acct := user.FromContext(ctx)
+			if acct == nil {
+				return netutil.TransmitUDPFromClient(ctx, proxyStore, timeout)
+			}
+			// UDP关联按一个连接计入用户配额
+			if err := acct.Acquire(); err != nil {
+				return nil, err
+			}
+			assoc, err := netutil.TransmitUDPFromClient(ctx, proxyStore, timeout)
+			if err != nil {
+				acct.Release()
+				return nil, err
+			}
+			go func() {
+				<-assoc.Done()
+				acct.Release()
+			}()
+			return assoc, nil
+		}
+		logger.Info("Socks5服务已启用UDP ASSOCIATE")
+	}
+
+	// 目标地址访问控制最先执行
+	acl, err := NewDestinationACL(aclCfg)
+	if err != nil {
+		logger.Error("Socks5服务访问控制规则错误: %v", err)
+		return
+	}
+	rules := ruleChain{acl}
+
+	// 开启多用户时按用户认证，否则使用配置的用户名和密码
 	userName := cfg.UserName
 	password := cfg.Password
-	if userName != "" && password != "" {
-		cator := socks5.UserPassAuthenticator{Credentials: socks5.StaticCredentials{
+	if user.Enabled() {
+		conf.Credentials = userCredentials{}
+		logger.Info("Socks5服务已启用多用户认证")
+	} else if userName != "" && password != "" {
+		conf.Credentials = StaticCredentials{
 			userName: password,
-		}}
-		conf.AuthMethods = []socks5.Authenticator{cator}
+		}
 		logger.Info("Socks5服务已启用认证，用户名: %s", userName)
 	}
-	
-	server, err := socks5.New(conf)
-	if err != nil {
-		logger.Error("Socks5服务初始化失败: %v", err)
-		return
+
+	// 用户名选路：user-country-CN-session-abc123-proto-socks5
+	// 未配置认证时不校验密码，客户端也可以免认证按默认轮询使用
+	if cfg.UsernameRouting {
+		if conf.Credentials == nil {
+			conf.AllowNoAuth = true
+		}
+		conf.Credentials = routingCredentials{base: conf.Credentials}
+		rules = append(rules, routingRules{})
+		logger.Info("Socks5服务已启用用户名选路参数")
+	}
+	// 监听的选路参数只填充用户名未指定的部分，用户的代理池限制最后校验
+	rules = append(rules, listenerRules{entry: cfg})
+	if user.Enabled() {
+		rules = append(rules, userRules{routing: cfg.UsernameRouting})
 	}
-	
+	conf.Rules = rules
+
+	if cfg.RaceDials > 1 {
+		logger.Info("Socks5服务已启用竞速拨号，每次同时尝试 %d 个上游", cfg.RaceDials)
+	}
+	if cfg.Strategy != "" || cfg.Rotation != "" {
+		logger.Info("Socks5服务 %s 选择策略: %s，轮换方式: %s", cfg.Name, cfg.Strategy, cfg.Rotation)
+	}
+
+	server := NewSocks5Server(conf)
+	lifecycle.OnShutdownFunc(lifecycle.PhaseListeners, "Socks5监听 "+cfg.Name, server.Close)
+	lifecycle.OnShutdown(lifecycle.PhaseDrain, "Socks5连接 "+cfg.Name, server.Shutdown)
+
 	listener := cfg.IP + ":" + strconv.Itoa(cfg.Port)
-	
-	if err := server.ListenAndServe("tcp", listener); err != nil {
-		logger.Error("Socks5服务启动失败: %v", err)
+
+	err = server.ListenAndServe("tcp", listener)
+	if errors.Is(err, ErrServerClosed) {
+		logger.Info("Socks5服务 %s 已停止监听", cfg.Name)
+	} else if err != nil {
+		logger.Error("Socks5服务 %s 启动失败: %v", cfg.Name, err)
 	}
-} 
\ No newline at end of file
+}
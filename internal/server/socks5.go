@@ -0,0 +1,415 @@
+package socks5server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/overflow0verture/proxy_harvester/internal/access"
+	"github.com/overflow0verture/proxy_harvester/internal/errclass"
+	"github.com/overflow0verture/proxy_harvester/internal/logger"
+	"github.com/overflow0verture/proxy_harvester/internal/netutil"
+	"github.com/overflow0verture/proxy_harvester/internal/user"
+)
+
+// ErrServerClosed 服务已关闭
+var ErrServerClosed = errors.New("服务已关闭")
+
+// Request 客户端发起的一次SOCKS5请求
+type Request struct {
+	Command    byte
+	DestAddr   string // 目标地址 host:port，UDP ASSOCIATE时为客户端声明的发送地址
+	RemoteAddr net.Addr
+	AuthUser   string // 认证用户名，未认证时为空
+}
+
+// CredentialStore 用户名密码校验
+type CredentialStore interface {
+	Valid(user, password string) bool
+}
+
+// StaticCredentials 固定的用户名密码表
+type StaticCredentials map[string]string
+
+// Valid 校验用户名密码
+func (s StaticCredentials) Valid(user, password string) bool {
+	pass, ok := s[user]
+	return ok && pass == password
+}
+
+// RuleSet 请求放行规则，可通过返回的ctx向Dial传递信息
+type RuleSet interface {
+	Allow(ctx context.Context, req *Request) (context.Context, bool)
+}
+
+// Socks5Config SOCKS5服务配置
+type Socks5Config struct {
+	// Name 监听名称，用于访问记录，为空时记为socks5
+	Name string
+	// Credentials 为nil时不要求认证
+	Credentials CredentialStore
+	// AllowNoAuth 设置了Credentials时是否仍允许客户端免认证
+	AllowNoAuth bool
+	// Rules 为nil时放行所有请求
+	Rules RuleSet
+	// Dial 建立到目标地址的TCP连接
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// DialUDP 建立上游UDP关联，为nil时不支持UDP ASSOCIATE
+	DialUDP func(ctx context.Context) (*netutil.UDPAssociation, error)
+	// OriginalDst 设置后作为透明代理：不进行SOCKS5握手，转发到从连接取得的原始目标地址
+	OriginalDst func(conn net.Conn) (string, error)
+}
+
+// Socks5Server 支持CONNECT和UDP ASSOCIATE的SOCKS5服务
+type Socks5Server struct {
+	config *Socks5Config
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]context.CancelFunc
+	active    sync.WaitGroup
+	closed    bool
+}
+
+// NewSocks5Server 创建SOCKS5服务
+func NewSocks5Server(conf *Socks5Config) *Socks5Server {
+	return &Socks5Server{
+		config:    conf,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]context.CancelFunc),
+	}
+}
+
+// ListenAndServe 监听并处理连接
+func (s *Socks5Server) ListenAndServe(network, addr string) error {
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve 处理监听器上的连接，Close后返回ErrServerClosed
+func (s *Socks5Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			delete(s.listeners, l)
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn 处理单个客户端连接
+func (s *Socks5Server) ServeConn(conn net.Conn) error {
+	// 强制关闭时取消ctx，使转发中的上游连接一并关闭
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if !s.track(conn, cancel) {
+		conn.Close()
+		return ErrServerClosed
+	}
+	defer s.untrack(conn)
+	defer conn.Close()
+
+	if s.config.OriginalDst != nil {
+		return s.serveTransparent(ctx, conn)
+	}
+
+	user, err := s.authenticate(conn)
+	if err != nil {
+		logger.Debug("SOCKS5认证失败 %s: %v", conn.RemoteAddr(), err)
+		return err
+	}
+
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[0] != netutil.Socks5Version {
+		return fmt.Errorf("不支持的SOCKS版本: %d", header[0])
+	}
+	dest, err := netutil.ReadSocksAddr(conn)
+	if err != nil {
+		if netutil.IsUnsupportedAddrType(err) {
+			sendReply(conn, netutil.Socks5RepAddrTypeNotSupported, nil)
+		}
+		return err
+	}
+
+	req := &Request{
+		Command:    header[1],
+		DestAddr:   dest,
+		RemoteAddr: conn.RemoteAddr(),
+		AuthUser:   user,
+	}
+
+	if s.config.Rules != nil {
+		var ok bool
+		if ctx, ok = s.config.Rules.Allow(ctx, req); !ok {
+			sendReply(conn, netutil.Socks5RepNotAllowed, nil)
+			return fmt.Errorf("请求被规则拒绝: %s", dest)
+		}
+	}
+
+	switch req.Command {
+	case netutil.Socks5CmdConnect:
+		return s.handleConnect(ctx, conn, req)
+	case netutil.Socks5CmdUDPAssociate:
+		if s.config.DialUDP == nil {
+			sendReply(conn, netutil.Socks5RepCommandNotSupported, nil)
+			return errors.New("未开启UDP ASSOCIATE")
+		}
+		return s.handleUDPAssociate(ctx, conn, req)
+	default:
+		sendReply(conn, netutil.Socks5RepCommandNotSupported, nil)
+		return fmt.Errorf("不支持的命令: %d", req.Command)
+	}
+}
+
+// track 记录活动连接，服务已关闭时返回false
+func (s *Socks5Server) track(conn net.Conn, cancel context.CancelFunc) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = cancel
+	s.active.Add(1)
+	return true
+}
+
+func (s *Socks5Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	s.active.Done()
+}
+
+// Close 停止接受新连接，已建立的连接继续转发
+func (s *Socks5Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	return nil
+}
+
+// Shutdown 停止接受新连接并等待活动连接结束，ctx到期时强制关闭剩余连接
+func (s *Socks5Server) Shutdown(ctx context.Context) error {
+	s.Close()
+	done := make(chan struct{})
+	go func() {
+		s.active.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	remaining := len(s.conns)
+	for conn, cancel := range s.conns {
+		cancel()
+		conn.Close()
+	}
+	s.mu.Unlock()
+	<-done
+	return fmt.Errorf("等待连接结束超时，强制关闭 %d 个连接", remaining)
+}
+
+// userPassVersion RFC 1929 用户名密码子协商的版本号
+const userPassVersion = 0x01
+
+// authenticate 协商认证方式并完成认证，返回用户名
+func (s *Socks5Server) authenticate(conn net.Conn) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[0] != netutil.Socks5Version {
+		return "", fmt.Errorf("不支持的SOCKS版本: %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+
+	// 优先使用用户名密码认证，未配置凭据或允许免认证时接受无认证
+	want := byte(netutil.Socks5MethodNoAcceptable)
+	for _, m := range methods {
+		if m == netutil.Socks5MethodUserPass && s.config.Credentials != nil {
+			want = netutil.Socks5MethodUserPass
+			break
+		}
+		if m == netutil.Socks5MethodNoAuth && (s.config.Credentials == nil || s.config.AllowNoAuth) {
+			want = netutil.Socks5MethodNoAuth
+		}
+	}
+	if want == netutil.Socks5MethodNoAcceptable {
+		conn.Write([]byte{netutil.Socks5Version, netutil.Socks5MethodNoAcceptable})
+		return "", errors.New("没有可接受的认证方式")
+	}
+	if _, err := conn.Write([]byte{netutil.Socks5Version, want}); err != nil {
+		return "", err
+	}
+	if want == netutil.Socks5MethodNoAuth {
+		return "", nil
+	}
+
+	// RFC 1929 用户名密码子协商
+	ver := make([]byte, 2)
+	if _, err := io.ReadFull(conn, ver); err != nil {
+		return "", err
+	}
+	if ver[0] != userPassVersion {
+		conn.Write([]byte{userPassVersion, 0x01})
+		return "", fmt.Errorf("不支持的用户名密码认证版本: %d", ver[0])
+	}
+	user := make([]byte, ver[1])
+	if _, err := io.ReadFull(conn, user); err != nil {
+		return "", err
+	}
+	plen := make([]byte, 1)
+	if _, err := io.ReadFull(conn, plen); err != nil {
+		return "", err
+	}
+	pass := make([]byte, plen[0])
+	if _, err := io.ReadFull(conn, pass); err != nil {
+		return "", err
+	}
+
+	if !s.config.Credentials.Valid(string(user), string(pass)) {
+		conn.Write([]byte{userPassVersion, 0x01})
+		return "", errors.New("用户名或密码错误")
+	}
+	if _, err := conn.Write([]byte{userPassVersion, 0x00}); err != nil {
+		return "", err
+	}
+	return string(user), nil
+}
+
+// handleConnect 处理CONNECT命令
+func (s *Socks5Server) handleConnect(ctx context.Context, conn net.Conn, req *Request) error {
+	listener := s.config.Name
+	if listener == "" {
+		listener = access.ListenerSocks5
+	}
+	rec := newAccessRecord(ctx, listener, req.RemoteAddr, req.AuthUser, req.DestAddr)
+	target, err := s.config.Dial(ctx, "tcp", req.DestAddr)
+	if err != nil {
+		access.Failed(rec, err)
+		s.reply(conn, replyCode(err), nil)
+		return fmt.Errorf("连接 %s 失败: %v", req.DestAddr, err)
+	}
+	defer target.Close()
+	stop := context.AfterFunc(ctx, func() {
+		target.Close()
+	})
+	defer stop()
+
+	finish := tunnelAccess(rec, target)
+	if err := s.reply(conn, netutil.Socks5RepSuccess, target.LocalAddr()); err != nil {
+		finish(access.CloseError, err)
+		return err
+	}
+
+	finish(relay(conn, target))
+	return nil
+}
+
+// relay 双向转发数据，任一方向结束后关闭写端
+// 返回先结束的一方作为关闭原因，读写出错时同时返回错误
+func relay(client, target net.Conn) (string, error) {
+	var (
+		wg     sync.WaitGroup
+		once   sync.Once
+		reason string
+		relErr error
+	)
+	wg.Add(2)
+	pipe := func(dst, src net.Conn, closed string) {
+		defer wg.Done()
+		_, err := io.Copy(dst, src)
+		once.Do(func() {
+			reason = closed
+			if err != nil && !errors.Is(err, net.ErrClosed) {
+				reason, relErr = access.CloseError, err
+			}
+		})
+		if tcp, ok := dst.(interface{ CloseWrite() error }); ok {
+			tcp.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+	go pipe(target, client, access.CloseClient)
+	go pipe(client, target, access.CloseUpstream)
+	wg.Wait()
+	return reason, relErr
+}
+
+// replyCode 将拨号错误映射为SOCKS5应答码
+func replyCode(err error) byte {
+	if errors.Is(err, user.ErrQuotaExceeded) {
+		return netutil.Socks5RepNotAllowed
+	}
+	if errors.Is(err, netutil.ErrNoProxy) {
+		return netutil.Socks5RepServerFailure
+	}
+	switch errclass.Classify(err) {
+	case errclass.ConnectRefused:
+		return netutil.Socks5RepConnectionRefused
+	case errclass.ConnectTimeout:
+		return netutil.Socks5RepTTLExpired
+	case errclass.Unsupported:
+		return netutil.Socks5RepCommandNotSupported
+	default:
+		return netutil.Socks5RepHostUnreachable
+	}
+}
+
+// reply 发送CONNECT应答，透明代理模式下客户端不需要应答
+func (s *Socks5Server) reply(conn net.Conn, rep byte, addr net.Addr) error {
+	if s.config.OriginalDst != nil {
+		return nil
+	}
+	return sendReply(conn, rep, addr)
+}
+
+// sendReply 发送SOCKS5应答，addr为nil时使用全零地址
+func sendReply(conn net.Conn, rep byte, addr net.Addr) error {
+	bind := "0.0.0.0:0"
+	if addr != nil {
+		bind = addr.String()
+	}
+	msg, err := netutil.AppendSocksAddr([]byte{netutil.Socks5Version, rep, 0x00}, bind)
+	if err != nil {
+		msg, _ = netutil.AppendSocksAddr([]byte{netutil.Socks5Version, rep, 0x00}, "0.0.0.0:0")
+	}
+	_, err = conn.Write(msg)
+	return err
+}
@@ -0,0 +1,214 @@
+package socks5server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/overflow0verture/proxy_harvester/internal/logger"
+	"github.com/overflow0verture/proxy_harvester/internal/netutil"
+	"golang.org/x/net/proxy"
+)
+
+func TestMain(m *testing.M) {
+	logger.Setup(false, "")
+	m.Run()
+}
+
+// startEcho 启动回显服务，返回监听地址
+func startEcho(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// startSocks5 启动SOCKS5服务，直接连接目标地址，返回监听地址
+func startSocks5(t *testing.T, conf *Socks5Config) string {
+	t.Helper()
+	if conf.Dial == nil {
+		dialer := &net.Dialer{Timeout: 2 * time.Second}
+		conf.Dial = dialer.DialContext
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSocks5Server(conf)
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String()
+}
+
+// dialRaw 建立到SOCKS5服务的原始连接
+func dialRaw(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// expect 读取应答并与want比较
+func expect(t *testing.T, conn net.Conn, want []byte) {
+	t.Helper()
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("读取应答失败: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("应答 = %v, want %v", got, want)
+	}
+}
+
+func TestSocks5Connect(t *testing.T) {
+	echo := startEcho(t)
+	tests := []struct {
+		name  string
+		conf  *Socks5Config
+		auth  *proxy.Auth
+		allow bool
+	}{
+		{"免认证", &Socks5Config{}, nil, true},
+		{"用户名密码", &Socks5Config{Credentials: StaticCredentials{"alice": "secret"}}, &proxy.Auth{User: "alice", Password: "secret"}, true},
+		{"密码错误", &Socks5Config{Credentials: StaticCredentials{"alice": "secret"}}, &proxy.Auth{User: "alice", Password: "wrong"}, false},
+		{"要求认证时拒绝免认证", &Socks5Config{Credentials: StaticCredentials{"alice": "secret"}}, nil, false},
+		{"允许免认证", &Socks5Config{Credentials: StaticCredentials{"alice": "secret"}, AllowNoAuth: true}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := startSocks5(t, tt.conf)
+			dialer, err := proxy.SOCKS5("tcp", addr, tt.auth, &net.Dialer{Timeout: 2 * time.Second})
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn, err := dialer.Dial("tcp", echo)
+			if !tt.allow {
+				if err == nil {
+					conn.Close()
+					t.Fatal("应拒绝连接")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CONNECT失败: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(2 * time.Second))
+			if _, err := conn.Write([]byte("ping")); err != nil {
+				t.Fatal(err)
+			}
+			expect(t, conn, []byte("ping"))
+		})
+	}
+}
+
+func TestSocks5Handshake(t *testing.T) {
+	addr := startSocks5(t, &Socks5Config{Credentials: StaticCredentials{"alice": "secret"}})
+
+	t.Run("不支持的版本", func(t *testing.T) {
+		conn := dialRaw(t, addr)
+		conn.Write([]byte{0x04, 0x01, 0x00})
+		if n, _ := conn.Read(make([]byte, 1)); n != 0 {
+			t.Fatal("不支持的版本应直接关闭连接")
+		}
+	})
+
+	t.Run("没有可接受的认证方式", func(t *testing.T) {
+		conn := dialRaw(t, addr)
+		conn.Write([]byte{netutil.Socks5Version, 0x01, netutil.Socks5MethodNoAuth})
+		expect(t, conn, []byte{netutil.Socks5Version, netutil.Socks5MethodNoAcceptable})
+	})
+
+	t.Run("子协商版本错误", func(t *testing.T) {
+		conn := dialRaw(t, addr)
+		conn.Write([]byte{netutil.Socks5Version, 0x01, netutil.Socks5MethodUserPass})
+		expect(t, conn, []byte{netutil.Socks5Version, netutil.Socks5MethodUserPass})
+		conn.Write([]byte{0x05, 0x05, 'a', 'l', 'i', 'c', 'e', 0x06, 's', 'e', 'c', 'r', 'e', 't'})
+		expect(t, conn, []byte{userPassVersion, 0x01})
+	})
+
+	t.Run("子协商成功", func(t *testing.T) {
+		conn := dialRaw(t, addr)
+		conn.Write([]byte{netutil.Socks5Version, 0x01, netutil.Socks5MethodUserPass})
+		expect(t, conn, []byte{netutil.Socks5Version, netutil.Socks5MethodUserPass})
+		conn.Write([]byte{userPassVersion, 0x05, 'a', 'l', 'i', 'c', 'e', 0x06, 's', 'e', 'c', 'r', 'e', 't'})
+		expect(t, conn, []byte{userPassVersion, 0x00})
+	})
+}
+
+// denyRules 拒绝所有请求
+type denyRules struct{}
+
+func (denyRules) Allow(ctx context.Context, req *Request) (context.Context, bool) {
+	return ctx, false
+}
+
+func TestSocks5Replies(t *testing.T) {
+	tests := []struct {
+		name string
+		conf *Socks5Config
+		cmd  byte
+		want byte
+	}{
+		{"规则拒绝", &Socks5Config{Rules: denyRules{}}, netutil.Socks5CmdConnect, netutil.Socks5RepNotAllowed},
+		{"BIND不支持", &Socks5Config{}, netutil.Socks5CmdBind, netutil.Socks5RepCommandNotSupported},
+		{"未开启UDP", &Socks5Config{}, netutil.Socks5CmdUDPAssociate, netutil.Socks5RepCommandNotSupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := dialRaw(t, startSocks5(t, tt.conf))
+			conn.Write([]byte{netutil.Socks5Version, 0x01, netutil.Socks5MethodNoAuth})
+			expect(t, conn, []byte{netutil.Socks5Version, netutil.Socks5MethodNoAuth})
+			req, _ := netutil.AppendSocksAddr([]byte{netutil.Socks5Version, tt.cmd, 0x00}, "127.0.0.1:1")
+			conn.Write(req)
+			expect(t, conn, []byte{netutil.Socks5Version, tt.want})
+		})
+	}
+
+	t.Run("不支持的地址类型", func(t *testing.T) {
+		conn := dialRaw(t, startSocks5(t, &Socks5Config{}))
+		conn.Write([]byte{netutil.Socks5Version, 0x01, netutil.Socks5MethodNoAuth})
+		expect(t, conn, []byte{netutil.Socks5Version, netutil.Socks5MethodNoAuth})
+		conn.Write([]byte{netutil.Socks5Version, netutil.Socks5CmdConnect, 0x00, 0x02, 1, 2, 3, 4, 0, 80})
+		expect(t, conn, []byte{netutil.Socks5Version, netutil.Socks5RepAddrTypeNotSupported})
+	})
+}
+
+func TestUDPAssociateNonTCPConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+
+	s := NewSocks5Server(&Socks5Config{})
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.handleUDPAssociate(context.Background(), server, &Request{Command: netutil.Socks5CmdUDPAssociate})
+		server.Close()
+	}()
+	expect(t, client, []byte{netutil.Socks5Version, netutil.Socks5RepServerFailure, 0x00, netutil.Socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+	if err := <-errc; err == nil {
+		t.Error("非TCP控制连接应返回错误")
+	}
+}
@@ -0,0 +1,90 @@
+package socks5server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/overflow0verture/proxy_harvester/internal/config"
+	"github.com/overflow0verture/proxy_harvester/internal/lifecycle"
+	"github.com/overflow0verture/proxy_harvester/internal/logger"
+	"github.com/overflow0verture/proxy_harvester/internal/netutil"
+	"github.com/overflow0verture/proxy_harvester/internal/pool"
+)
+
+// StartTransparentServer 启动透明代理监听，接收iptables REDIRECT/TPROXY重定向的TCP连接，
+// 按原始目标地址经代理池转发，选路、访问控制和访问记录与socks5监听相同（仅Linux）
+func StartTransparentServer(proxyStore pool.ProxyStore, cfg config.ListenerEntry, aclCfg config.ACLConfig, timeout int) {
+	logger.Info("透明代理服务 %s 启动中，监听地址: %s:%d", cfg.Name, cfg.IP, cfg.Port)
+
+	acl, err := NewDestinationACL(aclCfg)
+	if err != nil {
+		logger.Error("透明代理服务访问控制规则错误: %v", err)
+		return
+	}
+
+	listenAddr := cfg.IP + ":" + strconv.Itoa(cfg.Port)
+	ln, err := listenTransparent(listenAddr, cfg.TProxy)
+	if err != nil {
+		logger.Error("透明代理服务 %s 启动失败: %v", cfg.Name, err)
+		return
+	}
+
+	// 客户端不经过认证，不按用户统计，只使用监听的选路参数
+	server := NewSocks5Server(&Socks5Config{
+		Name:  cfg.Name,
+		Rules: ruleChain{acl, listenerRules{entry: cfg}},
+		Dial: traceDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return netutil.TransmitReqWithContext(ctx, network, addr, proxyStore, timeout)
+		}),
+		OriginalDst: func(conn net.Conn) (string, error) {
+			dest, err := originalDst(conn, cfg.TProxy)
+			if err != nil {
+				return "", err
+			}
+			// 直接连接监听端口的连接，转发会回到自身
+			if _, port, _ := net.SplitHostPort(dest); port == strconv.Itoa(cfg.Port) && dest == conn.LocalAddr().String() {
+				return "", errNotRedirected
+			}
+			return dest, nil
+		},
+	})
+	lifecycle.OnShutdownFunc(lifecycle.PhaseListeners, "透明代理监听 "+cfg.Name, server.Close)
+	lifecycle.OnShutdown(lifecycle.PhaseDrain, "透明代理连接 "+cfg.Name, server.Shutdown)
+
+	if cfg.TProxy {
+		logger.Info("透明代理服务 %s 使用TPROXY模式", cfg.Name)
+	}
+	err = server.Serve(ln)
+	if errors.Is(err, ErrServerClosed) {
+		logger.Info("透明代理服务 %s 已停止监听", cfg.Name)
+	} else if err != nil {
+		logger.Error("透明代理服务 %s 异常退出: %v", cfg.Name, err)
+	}
+}
+
+// errNotRedirected 连接未经iptables重定向，而是直接连接了透明代理的监听端口
+var errNotRedirected = errors.New("连接未经重定向")
+
+// serveTransparent 处理重定向到透明代理的连接
+func (s *Socks5Server) serveTransparent(ctx context.Context, conn net.Conn) error {
+	dest, err := s.config.OriginalDst(conn)
+	if err != nil {
+		logger.Debug("透明代理取原始目标地址失败 %s: %v", conn.RemoteAddr(), err)
+		return err
+	}
+	req := &Request{
+		Command:    netutil.Socks5CmdConnect,
+		DestAddr:   dest,
+		RemoteAddr: conn.RemoteAddr(),
+	}
+	if s.config.Rules != nil {
+		var ok bool
+		if ctx, ok = s.config.Rules.Allow(ctx, req); !ok {
+			return fmt.Errorf("请求被规则拒绝: %s", dest)
+		}
+	}
+	return s.handleConnect(ctx, conn, req)
+}
@@ -0,0 +1,98 @@
+package socks5server
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"syscall"
+)
+
+const (
+	soOriginalDst   = 80 // SO_ORIGINAL_DST，IPv6为IP6T_SO_ORIGINAL_DST，取值相同
+	ipv6Transparent = 75 // IPV6_TRANSPARENT
+)
+
+// listenTransparent 监听透明代理端口，tproxy为true时设置IP_TRANSPARENT以接收TPROXY转来的连接（需CAP_NET_ADMIN）
+func listenTransparent(addr string, tproxy bool) (net.Listener, error) {
+	lc := net.ListenConfig{}
+	if tproxy {
+		lc.Control = func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				if network == "tcp6" {
+					sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_IPV6, ipv6Transparent, 1)
+				} else {
+					sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_IP, syscall.IP_TRANSPARENT, 1)
+				}
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		}
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// originalDst 取得重定向前的目标地址
+// REDIRECT通过SO_ORIGINAL_DST从连接跟踪中取得；TPROXY不修改目标地址，连接的本地地址即原始目标
+func originalDst(conn net.Conn, tproxy bool) (string, error) {
+	if tproxy {
+		return conn.LocalAddr().String(), nil
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return "", errors.New("透明代理仅支持TCP连接")
+	}
+	local, _ := conn.LocalAddr().(*net.TCPAddr)
+	raw, err := tcpConn.SyscallConn()
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		dest    string
+		sockErr error
+	)
+	err = raw.Control(func(fd uintptr) {
+		if local != nil && local.IP.To4() == nil {
+			// sockaddr_in6 的长度与 IPv6MTUInfo 的首个字段一致
+			info, err := syscall.GetsockoptIPv6MTUInfo(int(fd), syscall.SOL_IPV6, soOriginalDst)
+			if err != nil {
+				sockErr = err
+				return
+			}
+			dest = net.JoinHostPort(net.IP(info.Addr.Addr[:]).String(), strconv.Itoa(int(networkPort(info.Addr.Port))))
+			return
+		}
+		// sockaddr_in 的长度与 IPv6Mreq 一致：family(2) port(2) addr(4) zero(8)
+		mreq, err := syscall.GetsockoptIPv6Mreq(int(fd), syscall.SOL_IP, soOriginalDst)
+		if err != nil {
+			sockErr = err
+			return
+		}
+		b := mreq.Multiaddr
+		port := int(b[2])<<8 | int(b[3])
+		dest = net.JoinHostPort(net.IPv4(b[4], b[5], b[6], b[7]).String(), strconv.Itoa(port))
+	})
+	if err != nil {
+		return "", err
+	}
+	// 没有对应的NAT连接跟踪记录
+	if errors.Is(sockErr, syscall.ENOENT) {
+		return "", errNotRedirected
+	}
+	if sockErr != nil {
+		return "", sockErr
+	}
+	return dest, nil
+}
+
+// networkPort 将按网络字节序存放的端口转为数值
+func networkPort(port uint16) uint16 {
+	var b [2]byte
+	binary.NativeEndian.PutUint16(b[:], port)
+	return binary.BigEndian.Uint16(b[:])
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package socks5server
+
+import (
+	"errors"
+	"net"
+)
+
+var errTransparentUnsupported = errors.New("透明代理仅支持Linux")
+
+func listenTransparent(addr string, tproxy bool) (net.Listener, error) {
+	return nil, errTransparentUnsupported
+}
+
+func originalDst(conn net.Conn, tproxy bool) (string, error) {
+	return "", errTransparentUnsupported
+}
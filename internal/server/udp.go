@@ -0,0 +1,133 @@
+package socks5server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/overflow0verture/proxy_harvester/internal/logger"
+	"github.com/overflow0verture/proxy_harvester/internal/netutil"
+	"github.com/overflow0verture/proxy_harvester/internal/user"
+)
+
+// handleUDPAssociate 处理UDP ASSOCIATE命令
+// 在监听IP上开放一个UDP中继端口，客户端数据报经上游socks5代理的UDP关联转发，
+// 控制连接断开时关联结束
+func (s *Socks5Server) handleUDPAssociate(ctx context.Context, conn net.Conn, req *Request) error {
+	localTCP, ok1 := conn.LocalAddr().(*net.TCPAddr)
+	clientTCP, ok2 := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok1 || !ok2 {
+		sendReply(conn, netutil.Socks5RepServerFailure, nil)
+		return fmt.Errorf("控制连接不是TCP连接: %s", conn.RemoteAddr())
+	}
+	localIP, clientIP := localTCP.IP, clientTCP.IP
+
+	relayConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: localIP})
+	if err != nil {
+		sendReply(conn, netutil.Socks5RepServerFailure, nil)
+		return fmt.Errorf("创建UDP中继失败: %v", err)
+	}
+	defer relayConn.Close()
+
+	upstream, err := s.config.DialUDP(ctx)
+	if err != nil {
+		sendReply(conn, netutil.Socks5RepServerFailure, nil)
+		return fmt.Errorf("建立上游UDP关联失败: %v", err)
+	}
+	defer upstream.Close()
+
+	if err := sendReply(conn, netutil.Socks5RepSuccess, relayConn.LocalAddr()); err != nil {
+		return err
+	}
+	logger.Debug("UDP关联建立: %s -> %s (上游 %s)", req.RemoteAddr, relayConn.LocalAddr(), upstream.Proxy)
+
+	var (
+		mu         sync.Mutex
+		clientAddr *net.UDPAddr
+	)
+	filter, _ := s.config.Rules.(DestinationFilter)
+	// 用户的数据报计入流量并按带宽限速，超出当日流量配额时结束关联
+	acct := user.FromContext(ctx)
+	quotaExceeded := func(err error) {
+		logger.Info("用户 %s UDP关联结束: %v", acct.Name(), err)
+		conn.Close()
+	}
+
+	// 客户端 -> 上游
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := relayConn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			// 只接受控制连接同一IP发来的数据报
+			if !from.IP.Equal(clientIP) {
+				continue
+			}
+			mu.Lock()
+			clientAddr = from
+			mu.Unlock()
+
+			dest, data, err := netutil.UnpackUDPDatagram(buf[:n])
+			if err != nil {
+				continue
+			}
+			if filter != nil && !filter.AllowDestination(ctx, dest) {
+				continue
+			}
+			if acct != nil {
+				if err := acct.Meter(len(data), 0); err != nil {
+					quotaExceeded(err)
+					return
+				}
+			}
+			if err := upstream.WriteTo(data, dest); err != nil {
+				return
+			}
+		}
+	}()
+
+	// 上游 -> 客户端
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := upstream.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			to := clientAddr
+			mu.Unlock()
+			if to == nil {
+				continue
+			}
+			if acct != nil {
+				if err := acct.Meter(0, n); err != nil {
+					quotaExceeded(err)
+					return
+				}
+			}
+			pkt, err := netutil.PackUDPDatagram(from, buf[:n])
+			if err != nil {
+				continue
+			}
+			relayConn.WriteToUDP(pkt, to)
+		}
+	}()
+
+	// 控制连接或上游关联任一结束即释放
+	done := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-upstream.Done():
+	}
+	logger.Debug("UDP关联结束: %s", req.RemoteAddr)
+	return nil
+}
@@ -0,0 +1,147 @@
+package socks5server
+
+import (
+	"context"
+	"net"
+
+	"github.com/overflow0verture/proxy_harvester/internal/logger"
+	"github.com/overflow0verture/proxy_harvester/internal/netutil"
+	"github.com/overflow0verture/proxy_harvester/internal/user"
+)
+
+// userCredentials 按用户存储校验用户名密码
+type userCredentials struct{}
+
+// Valid 校验用户名密码
+func (userCredentials) Valid(name, password string) bool {
+	_, ok := user.Authenticate(name, password)
+	return ok
+}
+
+// userRules 校验用户的目标地址和代理池限制，并将用户放入ctx
+// routing为true时认证用户名中带有选路参数，需先取出基础用户名
+type userRules struct {
+	routing bool
+}
+
+// Allow 校验请求是否在用户的访问范围内
+func (r userRules) Allow(ctx context.Context, req *Request) (context.Context, bool) {
+	name := req.AuthUser
+	if r.routing {
+		base, _, err := ParseRoutingUser(name)
+		if err != nil {
+			return ctx, false
+		}
+		name = base
+	}
+	acct, ok := user.Get(name)
+	if !ok {
+		return ctx, false
+	}
+
+	if req.Command == netutil.Socks5CmdConnect && !acct.AllowDestination(req.DestAddr) {
+		acct.Deny()
+		logger.Info("用户 %s 无权访问 %s", name, req.DestAddr)
+		return ctx, false
+	}
+
+	route := netutil.RouteFromContext(ctx)
+	changed := false
+	if route.Filter.Label != "" {
+		if !acct.AllowPool(route.Filter.Label) {
+			acct.Deny()
+			logger.Info("用户 %s 无权使用代理池 %s", name, route.Filter.Label)
+			return ctx, false
+		}
+	} else if pools := acct.AllowedPools(); len(pools) > 0 {
+		// 未指定代理池时在全部允许的代理池中选择，监听器已限定代理池时取交集
+		labels := pools
+		if len(route.Filter.Labels) > 0 {
+			labels = intersect(route.Filter.Labels, pools)
+			if len(labels) == 0 {
+				acct.Deny()
+				logger.Info("用户 %s 无权使用代理池 %v", name, route.Filter.Labels)
+				return ctx, false
+			}
+		}
+		route.Filter.Labels = labels
+		changed = true
+	}
+	if race := acct.User().RaceDials; race > 0 {
+		route.Race = race
+		changed = true
+	}
+	if changed {
+		ctx = netutil.WithRoute(ctx, route)
+	}
+	return user.WithAccount(ctx, acct), true
+}
+
+// AllowDestination 校验UDP数据报的目标是否在ctx中用户的访问范围内
+func (userRules) AllowDestination(ctx context.Context, addr string) bool {
+	acct := user.FromContext(ctx)
+	if acct == nil || acct.AllowDestination(addr) {
+		return true
+	}
+	acct.Deny()
+	logger.Info("用户 %s 无权访问 %s", acct.Name(), addr)
+	return false
+}
+
+// intersect 返回同时出现在a和b中的元素，保持a中的顺序
+func intersect(a, b []string) []string {
+	var out []string
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				out = append(out, x)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// ruleChain 依次执行多个规则，全部放行才放行
+type ruleChain []RuleSet
+
+// Allow 依次执行规则，前一个规则返回的ctx传给下一个
+func (c ruleChain) Allow(ctx context.Context, req *Request) (context.Context, bool) {
+	for _, rule := range c {
+		var ok bool
+		if ctx, ok = rule.Allow(ctx, req); !ok {
+			return ctx, false
+		}
+	}
+	return ctx, true
+}
+
+// AllowDestination 依次执行规则中的目标地址过滤
+func (c ruleChain) AllowDestination(ctx context.Context, addr string) bool {
+	for _, rule := range c {
+		if f, ok := rule.(DestinationFilter); ok && !f.AllowDestination(ctx, addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// accountDial 按ctx中的用户校验配额，返回的连接计入用户流量
+func accountDial(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		acct := user.FromContext(ctx)
+		if acct == nil {
+			return dial(ctx, network, addr)
+		}
+		if err := acct.Acquire(); err != nil {
+			logger.Info("用户 %s %v", acct.Name(), err)
+			return nil, err
+		}
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			acct.Release()
+			return nil, err
+		}
+		return acct.Wrap(conn), nil
+	}
+}
@@ -0,0 +1,96 @@
+package user
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// meteredConn 统计用户流量并按带宽上限限速的连接，关闭时释放占用的连接数
+type meteredConn struct {
+	net.Conn
+	account *Account
+	once    sync.Once
+}
+
+// Wrap 包装已通过Acquire占用的连接
+func (a *Account) Wrap(conn net.Conn) net.Conn {
+	return &meteredConn{Conn: conn, account: a}
+}
+
+// Read 读取上游数据，计入下行流量，当日流量已达配额时返回ErrQuotaExceeded
+func (c *meteredConn) Read(b []byte) (int, error) {
+	if err := c.account.checkTraffic(); err != nil {
+		return 0, err
+	}
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.account.throttle(n)
+		c.account.addTraffic(0, n)
+	}
+	return n, err
+}
+
+// Write 写入上游数据，计入上行流量，当日流量已达配额时返回ErrQuotaExceeded
+func (c *meteredConn) Write(b []byte) (int, error) {
+	if err := c.account.checkTraffic(); err != nil {
+		return 0, err
+	}
+	c.account.throttle(len(b))
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.account.addTraffic(n, 0)
+	}
+	return n, err
+}
+
+// CloseWrite 关闭写端，底层连接不支持时直接关闭
+func (c *meteredConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Close()
+}
+
+// Close 关闭连接并释放连接数
+func (c *meteredConn) Close() error {
+	c.once.Do(c.account.Release)
+	return c.Conn.Close()
+}
+
+// limiter 令牌桶限速，桶容量为一秒的流量
+type limiter struct {
+	mu     sync.Mutex
+	rate   float64 // 字节/秒
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(bytesPerSec int) *limiter {
+	return &limiter{
+		rate:   float64(bytesPerSec),
+		tokens: float64(bytesPerSec),
+		last:   time.Now(),
+	}
+}
+
+// wait 消耗n个令牌，令牌不足时等待
+func (l *limiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
@@ -0,0 +1,291 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/overflow0verture/proxy_harvester/internal/config"
+	"github.com/overflow0verture/proxy_harvester/internal/logger"
+)
+
+// UserStore 用户存储统一接口，支持本地文件和Redis两种实现
+type UserStore interface {
+	Get(name string) (User, bool)         // 按用户名查询
+	GetByToken(token string) (User, bool) // 按API令牌查询
+	List() ([]User, error)                // 获取所有用户
+}
+
+// FileUserStore 本地JSON文件实现，文件修改后自动重新加载
+type FileUserStore struct {
+	mu       sync.Mutex
+	filename string
+	modTime  time.Time
+	users    map[string]User
+}
+
+// NewFileUserStore 从JSON文件加载用户列表
+func NewFileUserStore(filename string) *FileUserStore {
+	store := &FileUserStore{
+		filename: filename,
+		users:    make(map[string]User),
+	}
+	if err := store.reload(); err != nil {
+		logger.Error("无法从文件加载用户: %v", err)
+	}
+	return store
+}
+
+// reload 文件有变化时重新加载，调用方无需持有锁
+func (s *FileUserStore) reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.filename)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(s.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.filename)
+	if err != nil {
+		return err
+	}
+	var list []User
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("解析用户文件失败: %v", err)
+	}
+	users := make(map[string]User, len(list))
+	for _, u := range list {
+		if u.Name == "" {
+			continue
+		}
+		users[u.Name] = u
+	}
+	s.users = users
+	s.modTime = info.ModTime()
+	logger.Info("已从文件加载 %d 个用户", len(users))
+	return nil
+}
+
+// Get 按用户名查询
+func (s *FileUserStore) Get(name string) (User, bool) {
+	s.reload()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[name]
+	return u, ok
+}
+
+// GetByToken 按API令牌查询
+func (s *FileUserStore) GetByToken(token string) (User, bool) {
+	s.reload()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.Token != "" && u.Token == token {
+			return u, true
+		}
+	}
+	return User{}, false
+}
+
+// List 获取所有用户
+func (s *FileUserStore) List() ([]User, error) {
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]User, 0, len(s.users))
+	for _, u := range s.users {
+		list = append(list, u)
+	}
+	return list, nil
+}
+
+// RedisUserStore Redis实现，用户以JSON保存在哈希表中，字段名为用户名
+type RedisUserStore struct {
+	client *redis.Client
+	key    string
+	ctx    context.Context
+}
+
+// NewRedisUserStore 新建Redis用户存储
+func NewRedisUserStore(host string, port int, password string) *RedisUserStore {
+	client := redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%d", host, port),
+		Password: password,
+		DB:       0,
+	})
+	return &RedisUserStore{
+		client: client,
+		key:    "proxy_users",
+		ctx:    context.Background(),
+	}
+}
+
+// Get 按用户名查询
+func (s *RedisUserStore) Get(name string) (User, bool) {
+	data, err := s.client.HGet(s.ctx, s.key, name).Result()
+	if err != nil {
+		return User{}, false
+	}
+	var u User
+	if err := json.Unmarshal([]byte(data), &u); err != nil {
+		logger.Error("解析Redis用户 %s 失败: %v", name, err)
+		return User{}, false
+	}
+	u.Name = name
+	return u, true
+}
+
+// GetByToken 按API令牌查询
+func (s *RedisUserStore) GetByToken(token string) (User, bool) {
+	list, err := s.List()
+	if err != nil {
+		return User{}, false
+	}
+	for _, u := range list {
+		if u.Token != "" && u.Token == token {
+			return u, true
+		}
+	}
+	return User{}, false
+}
+
+// List 获取所有用户
+func (s *RedisUserStore) List() ([]User, error) {
+	all, err := s.client.HGetAll(s.ctx, s.key).Result()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]User, 0, len(all))
+	for name, data := range all {
+		var u User
+		if err := json.Unmarshal([]byte(data), &u); err != nil {
+			logger.Error("解析Redis用户 %s 失败: %v", name, err)
+			continue
+		}
+		u.Name = name
+		list = append(list, u)
+	}
+	return list, nil
+}
+
+// InitUserStore 按配置创建用户存储
+func InitUserStore(cfg config.UsersConfig, storage config.StorageConfig) UserStore {
+	if cfg.Store == "storage" && storage.Type == "redis" {
+		logger.Info("使用Redis作为用户存储")
+		return NewRedisUserStore(storage.RedisHost, storage.RedisPort, storage.RedisPassword)
+	}
+	logger.Info("使用本地文件作为用户存储: %s", cfg.File)
+	return NewFileUserStore(cfg.File)
+}
+
+// 全局用户表，store为nil表示未开启多用户
+var (
+	registryMu sync.Mutex
+	store      UserStore
+	accounts   = make(map[string]*Account)
+)
+
+// Init 设置用户存储，开启多用户认证
+func Init(s UserStore) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	store = s
+}
+
+// Enabled 是否开启了多用户认证
+func Enabled() bool {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	return store != nil
+}
+
+// account 获取或创建用户的运行时状态，并同步最新配置
+func account(u User) *Account {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	a, ok := accounts[u.Name]
+	if !ok {
+		a = newAccount(u)
+		accounts[u.Name] = a
+		return a
+	}
+	a.update(u)
+	return a
+}
+
+func currentStore() UserStore {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	return store
+}
+
+// Authenticate 校验监听的用户名密码
+func Authenticate(name, password string) (*Account, bool) {
+	s := currentStore()
+	if s == nil {
+		return nil, false
+	}
+	u, ok := s.Get(name)
+	if !ok || u.Password == "" || u.Password != password {
+		return nil, false
+	}
+	return account(u), true
+}
+
+// Get 按用户名获取用户
+func Get(name string) (*Account, bool) {
+	s := currentStore()
+	if s == nil {
+		return nil, false
+	}
+	u, ok := s.Get(name)
+	if !ok {
+		return nil, false
+	}
+	return account(u), true
+}
+
+// ByToken 按API令牌获取用户
+func ByToken(token string) (*Account, bool) {
+	s := currentStore()
+	if s == nil || token == "" {
+		return nil, false
+	}
+	u, ok := s.GetByToken(token)
+	if !ok {
+		return nil, false
+	}
+	return account(u), true
+}
+
+// Accounts 获取所有用户，按用户名排序
+func Accounts() ([]*Account, error) {
+	s := currentStore()
+	if s == nil {
+		return nil, nil
+	}
+	list, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	result := make([]*Account, 0, len(list))
+	for _, u := range list {
+		result = append(result, account(u))
+	}
+	return result, nil
+}
@@ -0,0 +1,262 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrQuotaExceeded 超出用户配额（连接数、请求数或流量）
+var ErrQuotaExceeded = errors.New("超出用户配额")
+
+// User 用户配置，数值类配额为0表示不限制
+type User struct {
+	Name     string `json:"name"`
+	Password string `json:"password"` // 本地SOCKS5/HTTP监听的密码
+	Token    string `json:"token"`    // API访问令牌
+	// MaxConns 同时打开的连接数上限
+	MaxConns int `json:"max_conns"`
+	// BandwidthKBps 所有连接共享的带宽上限（KB/s）
+	BandwidthKBps int `json:"bandwidth_kbps"`
+	// DailyRequests 每日请求（连接）数上限
+	DailyRequests int64 `json:"daily_requests"`
+	// DailyTrafficMB 每日流量上限（MB，上下行合计）
+	DailyTrafficMB int64 `json:"daily_traffic_mb"`
+	// AllowedDestinations 允许访问的目标，支持通配符，如 *.example.com、example.com:443、*:443，为空不限制
+	AllowedDestinations []string `json:"allowed_destinations"`
+	// AllowedPools 允许使用的代理池（来源插件名或标签），为空不限制
+	AllowedPools []string `json:"allowed_pools"`
+	// RaceDials 同时竞速拨号的上游数，大于0时覆盖监听的配置
+	RaceDials int `json:"race_dials"`
+	// FeedbackWeight 通过API反馈代理使用结果的权重(0-1]，为0使用默认值，小于0忽略该用户的反馈
+	FeedbackWeight float64 `json:"feedback_weight"`
+}
+
+// Usage 用户使用量，按天统计的计数在跨天后清零
+type Usage struct {
+	Day         string `json:"day"`
+	ActiveConns int64  `json:"active_conns"`
+	TotalConns  int64  `json:"total_conns"`
+	Requests    int64  `json:"requests"`   // 当日请求数
+	BytesUp     int64  `json:"bytes_up"`   // 当日上行字节数
+	BytesDown   int64  `json:"bytes_down"` // 当日下行字节数
+	Denied      int64  `json:"denied"`     // 被拒绝的请求数
+}
+
+// Account 用户及其运行时状态
+type Account struct {
+	mu      sync.Mutex
+	user    User
+	usage   Usage
+	limiter *limiter
+}
+
+func newAccount(u User) *Account {
+	a := &Account{}
+	a.update(u)
+	return a
+}
+
+// update 更新用户配置，保留已有的使用量
+func (a *Account) update(u User) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.limiter == nil || a.user.BandwidthKBps != u.BandwidthKBps {
+		a.limiter = nil
+		if u.BandwidthKBps > 0 {
+			a.limiter = newLimiter(u.BandwidthKBps * 1024)
+		}
+	}
+	a.user = u
+}
+
+// Name 用户名
+func (a *Account) Name() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.user.Name
+}
+
+// User 用户配置的副本
+func (a *Account) User() User {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	u := a.user
+	u.AllowedDestinations = append([]string(nil), a.user.AllowedDestinations...)
+	u.AllowedPools = append([]string(nil), a.user.AllowedPools...)
+	return u
+}
+
+// Usage 当前使用量
+func (a *Account) Usage() Usage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rollDay()
+	return a.usage
+}
+
+// rollDay 跨天时清零当日计数，调用方需持有mu
+func (a *Account) rollDay() {
+	day := time.Now().Format("2006-01-02")
+	if a.usage.Day != day {
+		a.usage.Day = day
+		a.usage.Requests = 0
+		a.usage.BytesUp = 0
+		a.usage.BytesDown = 0
+	}
+}
+
+// AllowDestination 判断是否允许访问目标地址 host:port
+func (a *Account) AllowDestination(addr string) bool {
+	a.mu.Lock()
+	patterns := a.user.AllowedDestinations
+	a.mu.Unlock()
+	if len(patterns) == 0 {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	host = strings.ToLower(host)
+	addr = strings.ToLower(addr)
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		target := host
+		if strings.Contains(p, ":") {
+			target = addr
+		}
+		if ok, _ := path.Match(p, target); ok {
+			return true
+		}
+		// *.example.com 同时匹配 example.com
+		if strings.HasPrefix(p, "*.") && target == p[2:] {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowPool 校验请求的代理池是否在允许范围内，未限制代理池时均允许
+func (a *Account) AllowPool(label string) bool {
+	pools := a.AllowedPools()
+	if len(pools) == 0 {
+		return true
+	}
+	for _, p := range pools {
+		if p == label {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowedPools 用户允许使用的代理池，为空表示不限制
+func (a *Account) AllowedPools() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string(nil), a.user.AllowedPools...)
+}
+
+// Acquire 占用一个连接并计入请求数，超出配额时返回ErrQuotaExceeded
+func (a *Account) Acquire() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rollDay()
+
+	u := a.user
+	switch {
+	case u.MaxConns > 0 && a.usage.ActiveConns >= int64(u.MaxConns):
+		a.usage.Denied++
+		return fmt.Errorf("%w: 连接数已达上限 %d", ErrQuotaExceeded, u.MaxConns)
+	case u.DailyRequests > 0 && a.usage.Requests >= u.DailyRequests:
+		a.usage.Denied++
+		return fmt.Errorf("%w: 当日请求数已达上限 %d", ErrQuotaExceeded, u.DailyRequests)
+	case u.DailyTrafficMB > 0 && a.usage.BytesUp+a.usage.BytesDown >= u.DailyTrafficMB*1024*1024:
+		a.usage.Denied++
+		return fmt.Errorf("%w: 当日流量已达上限 %dMB", ErrQuotaExceeded, u.DailyTrafficMB)
+	}
+
+	a.usage.ActiveConns++
+	a.usage.TotalConns++
+	a.usage.Requests++
+	return nil
+}
+
+// Release 释放Acquire占用的连接
+func (a *Account) Release() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.usage.ActiveConns > 0 {
+		a.usage.ActiveConns--
+	}
+}
+
+// Deny 记录一次被拒绝的请求
+func (a *Account) Deny() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.usage.Denied++
+}
+
+// checkTraffic 当日流量已达配额时返回ErrQuotaExceeded
+func (a *Account) checkTraffic() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rollDay()
+	limit := a.user.DailyTrafficMB
+	if limit > 0 && a.usage.BytesUp+a.usage.BytesDown >= limit*1024*1024 {
+		a.usage.Denied++
+		return fmt.Errorf("%w: 当日流量已达上限 %dMB", ErrQuotaExceeded, limit)
+	}
+	return nil
+}
+
+// Meter 计入不经过Wrap连接的流量（如UDP数据报）并按带宽上限限速
+// 当日流量已达配额时不计入并返回ErrQuotaExceeded
+func (a *Account) Meter(up, down int) error {
+	if err := a.checkTraffic(); err != nil {
+		return err
+	}
+	a.throttle(up + down)
+	a.addTraffic(up, down)
+	return nil
+}
+
+// addTraffic 记录流量
+func (a *Account) addTraffic(up, down int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rollDay()
+	a.usage.BytesUp += int64(up)
+	a.usage.BytesDown += int64(down)
+}
+
+// throttle 按带宽上限等待
+func (a *Account) throttle(n int) {
+	a.mu.Lock()
+	l := a.limiter
+	a.mu.Unlock()
+	if l != nil {
+		l.wait(n)
+	}
+}
+
+type accountKey struct{}
+
+// WithAccount 将用户放入ctx
+func WithAccount(ctx context.Context, a *Account) context.Context {
+	return context.WithValue(ctx, accountKey{}, a)
+}
+
+// FromContext 取出ctx中的用户，未认证时返回nil
+func FromContext(ctx context.Context) *Account {
+	a, _ := ctx.Value(accountKey{}).(*Account)
+	return a
+}